@@ -0,0 +1,344 @@
+package detect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// BenchmarkOptions controls DetectFastest.
+type BenchmarkOptions struct {
+	// Directory is where the files each driver searches come from. It should
+	// be the directory that will be searched afterwards, or one like it.
+	Directory string
+	// SampleFiles is the most files copied out of Directory to be searched.
+	// If it's zero, DefaultSampleFiles is used.
+	SampleFiles int
+	// SampleSize is the most bytes copied out of Directory to be searched.
+	// If it's zero, DefaultSampleSize is used.
+	SampleSize int64
+	// Query is the literal string searched for. If it's empty,
+	// DefaultBenchmarkQuery is used.
+	Query string
+	// Budget is the most time spent searching, shared out evenly between the
+	// drivers. A driver that doesn't finish in its share isn't picked. If
+	// it's zero, DefaultBenchmarkBudget is used.
+	Budget time.Duration
+	// CacheFile is where decisions are remembered, if it's set, so later
+	// calls for the same directory and query don't have to search again.
+	// They're only reused while the same versions of the same drivers are
+	// installed. DefaultCacheFile returns a path in the user's cache
+	// directory.
+	CacheFile string
+}
+
+const (
+	DefaultBenchmarkQuery  = "TODO"
+	DefaultBenchmarkBudget = 2 * time.Second
+	DefaultSampleFiles     = 1000
+	DefaultSampleSize      = 32 << 20
+)
+
+// DefaultCacheFile returns the path of a file in the user's cache directory
+// to use as BenchmarkOptions.CacheFile.
+func DefaultCacheFile() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("detect.DefaultCacheFile: %w", err)
+	}
+
+	return filepath.Join(dir, "searchfiles", "detect.json"), nil
+}
+
+// DetectFastest is like DetectAll, but rather than picking the first working
+// driver, it times a search with each of them and picks the fastest. What's
+// searched is a sample of the files in options.Directory, copied to a
+// temporary directory, so that the time it takes doesn't grow with the size
+// of the tree. Each driver searches twice, and only the second search is
+// timed, so drivers that go later don't win just because the files are
+// already in the page cache. Drivers whose results differ from what most of
+// the others found are never picked. If none of them finish in time, it
+// fails with ErrBenchmarkTimeout, and nothing is cached, since nothing was
+// measured.
+func DetectFastest(ctx context.Context, searchOrder []string, options BenchmarkOptions) (string, error) {
+	if options.Query == "" {
+		options.Query = DefaultBenchmarkQuery
+	}
+	if options.Budget <= 0 {
+		options.Budget = DefaultBenchmarkBudget
+	}
+	if options.SampleFiles <= 0 {
+		options.SampleFiles = DefaultSampleFiles
+	}
+	if options.SampleSize <= 0 {
+		options.SampleSize = DefaultSampleSize
+	}
+
+	driverNames, err := DetectAll(ctx, searchOrder)
+	if err != nil {
+		return "", fmt.Errorf("detect.DetectFastest: %w", err)
+	}
+
+	directory, err := filepath.Abs(options.Directory)
+	if err != nil {
+		return "", fmt.Errorf("detect.DetectFastest: %w", err)
+	}
+
+	key := cacheKey(ctx, directory, options.Query, driverNames)
+
+	if options.CacheFile != "" {
+		if driverName, ok := readCache(options.CacheFile)[key]; ok {
+			return driverName, nil
+		}
+	}
+
+	sampleDirectory, err := os.MkdirTemp("", "searchfiles-sample-")
+	if err != nil {
+		return "", fmt.Errorf("detect.DetectFastest: %w", err)
+	}
+	defer os.RemoveAll(sampleDirectory)
+
+	if err := sample(directory, sampleDirectory, options.SampleFiles, options.SampleSize); err != nil {
+		return "", fmt.Errorf("detect.DetectFastest: %w", err)
+	}
+
+	driverName := fastest(ctx, driverNames, sampleDirectory, options.Query, options.Budget/time.Duration(2*len(driverNames)))
+	if err := ctx.Err(); err != nil {
+		return "", fmt.Errorf("detect.DetectFastest: %w", err)
+	}
+
+	if driverName == "" {
+		return "", fmt.Errorf("detect.DetectFastest: %w", ErrBenchmarkTimeout)
+	}
+
+	if options.CacheFile != "" {
+		// The decision is still good even if it can't be remembered, so
+		// failing to write it only means searching again next time.
+		_ = writeCache(options.CacheFile, key, driverName)
+	}
+
+	return driverName, nil
+}
+
+// fastest returns the quickest of driverNames to search directory for query
+// whose results agree with the majority, or "" if none of them finished in
+// time.
+func fastest(ctx context.Context, driverNames []string, directory, query string, timeout time.Duration) string {
+	type run struct {
+		driverName string
+		results    string
+		elapsed    time.Duration
+	}
+
+	var runs []run
+
+	for _, driverName := range driverNames {
+		var (
+			results []string
+			elapsed time.Duration
+			served  string
+			err     error
+		)
+
+		// With a fallback order set, another driver could end up doing the
+		// search, so the one that did is checked.
+		for i := 0; i < 2 && err == nil; i++ {
+			runCtx, cancel := context.WithTimeout(ctx, timeout)
+			start := time.Now()
+			served, err = searchfiles.ServedBy(runCtx, func(ctx context.Context) error {
+				var err error
+				results, err = searchfiles.SearchLiteralUsing(ctx, driverName, directory, query)
+				return err
+			})
+			elapsed = time.Since(start)
+			cancel()
+		}
+		if err != nil || served != driverName {
+			continue
+		}
+
+		sort.Strings(results)
+		runs = append(runs, run{driverName, strings.Join(results, "\x00"), elapsed})
+	}
+
+	// The order of runs follows the search order, so when there's a tie,
+	// the results found by the earlier driver win.
+	votes := map[string]int{}
+	majority := ""
+	for _, r := range runs {
+		votes[r.results]++
+		if votes[r.results] > votes[majority] {
+			majority = r.results
+		}
+	}
+
+	var best *run
+	for i, r := range runs {
+		if r.results == majority && (best == nil || r.elapsed < best.elapsed) {
+			best = &runs[i]
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+
+	return best.driverName
+}
+
+// sample copies up to files regular files, and size bytes, from directory to
+// the same places under dst, in the order a walk finds them. Hidden files and
+// directories are left out, as the drivers skip them anyway, along with
+// anything that can't be read.
+func sample(directory, dst string, files int, size int64) error {
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if path == directory {
+			return err
+		}
+		if err != nil {
+			return nil
+		}
+
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() > size {
+			return nil
+		}
+
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			return nil
+		}
+
+		if err := copyFile(path, filepath.Join(dst, rel)); err != nil {
+			return err
+		}
+
+		files--
+		size -= info.Size()
+		if files == 0 {
+			return fs.SkipAll
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("detect.sample: %w", err)
+	}
+
+	return nil
+}
+
+// copyFile copies src to dst, making dst's directory if need be. A src that
+// can't be opened is left out rather than failing.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("detect.copyFile: %w", err)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("detect.copyFile: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("detect.copyFile: %w", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("detect.copyFile: %w", err)
+	}
+
+	return nil
+}
+
+// cacheKey identifies a decision by what was searched and which drivers
+// were available to search it, down to their versions.
+func cacheKey(ctx context.Context, directory, query string, driverNames []string) string {
+	parts := []string{directory, query}
+
+	for _, driverName := range driverNames {
+		if v, err := searchfiles.DriverVersion(ctx, driverName); err == nil {
+			driverName += " " + v.String()
+		}
+		parts = append(parts, driverName)
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+// readCache returns the decisions in file. A missing or unreadable file is
+// treated as empty, since the worst that happens is searching again.
+func readCache(file string) map[string]string {
+	decisions := map[string]string{}
+
+	if b, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(b, &decisions)
+	}
+
+	return decisions
+}
+
+func writeCache(file, key, driverName string) error {
+	decisions := readCache(file)
+	decisions[key] = driverName
+
+	b, err := json.Marshal(decisions)
+	if err != nil {
+		return fmt.Errorf("detect.writeCache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("detect.writeCache: %w", err)
+	}
+
+	// Writing to another file and renaming it means a reader never sees it
+	// half written.
+	fd, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("detect.writeCache: %w", err)
+	}
+
+	if _, err := fd.Write(b); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return fmt.Errorf("detect.writeCache: %w", err)
+	}
+
+	if err := fd.Close(); err != nil {
+		os.Remove(fd.Name())
+		return fmt.Errorf("detect.writeCache: %w", err)
+	}
+
+	if err := os.Rename(fd.Name(), file); err != nil {
+		os.Remove(fd.Name())
+		return fmt.Errorf("detect.writeCache: %w", err)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package detect
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"fknsrs.biz/p/searchfiles"
@@ -13,23 +14,131 @@ import (
 )
 
 var (
-	ErrNoWorkingDriver = fmt.Errorf("no working driver found")
+	ErrNoWorkingDriver  = fmt.Errorf("no working driver found")
+	ErrBenchmarkTimeout = fmt.Errorf("no driver finished the benchmark in time")
 )
 
 var DefaultSearchOrder = []string{"ag", "rg", "grep", "pt", "native"}
 
+// Requirement is what the program a driver runs has to be for the driver to
+// be detected.
+type Requirement struct {
+	// Flavor is the only flavor accepted, if it's set, such as "gnu" for
+	// grep.
+	Flavor string
+	// MinVersion is the oldest version accepted.
+	MinVersion searchfiles.Version
+}
+
+// DefaultRequirements are the requirements used when none are given. The rg
+// driver relies on --no-ignore-exclude, which rg has had since 12.0.0. Other
+// drivers check what their programs can do when they're asked to search, so
+// for example BSD grep is still detected, but can't search for a regexp.
+var DefaultRequirements = map[string]Requirement{
+	"rg": {MinVersion: searchfiles.Version{Major: 12}},
+}
+
 func Detect(ctx context.Context, searchOrder []string) (string, error) {
+	driverName, err := DetectWithRequirements(ctx, searchOrder, nil)
+	if err != nil {
+		return "", fmt.Errorf("detect.Detect: %w", err)
+	}
+
+	return driverName, nil
+}
+
+// DetectWithRequirements is like Detect, but skips drivers whose programs
+// don't meet their entry in requirements. Drivers without an entry only have
+// to pass their self test. If requirements is nil, DefaultRequirements is
+// used.
+func DetectWithRequirements(ctx context.Context, searchOrder []string, requirements map[string]Requirement) (string, error) {
+	if searchOrder == nil {
+		searchOrder = DefaultSearchOrder
+	}
+
+	for _, driverName := range searchOrder {
+		if works(ctx, driverName, requirements) {
+			return driverName, nil
+		}
+	}
+
+	return "", fmt.Errorf("detect.DetectWithRequirements: %w", ErrNoWorkingDriver)
+}
+
+// works reports whether the named driver passes its self test, and meets its
+// requirement, if it has one.
+func works(ctx context.Context, driverName string, requirements map[string]Requirement) bool {
+	if requirements == nil {
+		requirements = DefaultRequirements
+	}
+
+	if err := searchfiles.TestDriver(ctx, driverName); err != nil {
+		return false
+	}
+
+	requirement, ok := requirements[driverName]
+	if !ok {
+		return true
+	}
+
+	v, err := searchfiles.DriverVersion(ctx, driverName)
+	if err != nil {
+		return false
+	}
+
+	if requirement.Flavor != "" && v.Flavor != requirement.Flavor {
+		return false
+	}
+
+	return v.Compare(requirement.MinVersion) >= 0
+}
+
+// DetectFor is like Detect, but skips drivers that say they can't search
+// with options. Drivers that don't say are assumed to be able to.
+func DetectFor(ctx context.Context, searchOrder []string, options searchfiles.SearchOptions) (string, error) {
 	if searchOrder == nil {
 		searchOrder = DefaultSearchOrder
 	}
 
+	required := searchfiles.RequiredCapabilities(options)
+
 	for _, driverName := range searchOrder {
-		if err := searchfiles.TestDriver(ctx, driverName); err == nil {
+		if !works(ctx, driverName, nil) {
+			continue
+		}
+
+		capabilities, err := searchfiles.DriverCapabilities(ctx, driverName)
+		if errors.Is(err, searchfiles.ErrUnimplemented) {
+			return driverName, nil
+		}
+		if err == nil && capabilities.Has(required) {
 			return driverName, nil
 		}
 	}
 
-	return "", fmt.Errorf("detect.Detect: %w", ErrNoWorkingDriver)
+	return "", fmt.Errorf("detect.DetectFor: %w", ErrNoWorkingDriver)
+}
+
+// DetectAll is like Detect, but returns every working driver in searchOrder,
+// in the same order.
+func DetectAll(ctx context.Context, searchOrder []string) ([]string, error) {
+	if searchOrder == nil {
+		searchOrder = DefaultSearchOrder
+	}
+
+	var driverNames []string
+
+	for _, driverName := range searchOrder {
+		if works(ctx, driverName, nil) {
+			driverNames = append(driverNames, driverName)
+		}
+	}
+
+	if len(driverNames) == 0 {
+		return nil, fmt.Errorf("detect.DetectAll: %w", ErrNoWorkingDriver)
+	}
+
+	return driverNames, nil
 }
 
 func DetectAndSetPreferred(ctx context.Context, searchOrder []string) (string, error) {
@@ -42,3 +151,18 @@ func DetectAndSetPreferred(ctx context.Context, searchOrder []string) (string, e
 
 	return driverName, nil
 }
+
+// DetectAndSetFallback is like DetectAndSetPreferred, but also makes the rest
+// of the working drivers the fallback order, so searches move on to them if
+// the preferred driver stops working.
+func DetectAndSetFallback(ctx context.Context, searchOrder []string) (string, error) {
+	driverNames, err := DetectAll(ctx, searchOrder)
+	if err != nil {
+		return "", fmt.Errorf("detect.DetectAndSetFallback: %w", err)
+	}
+
+	searchfiles.SetPreferredDriver(driverNames[0])
+	searchfiles.SetFallbackOrder(driverNames)
+
+	return driverNames[0], nil
+}
@@ -2,7 +2,11 @@ package detect
 
 import (
 	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -84,3 +88,189 @@ func TestDetectBrokenExceptNative(t *testing.T) {
 	a.NoError(err)
 	a.Equal("native", driverName)
 }
+
+func TestDetectAllBrokenExceptGrep(t *testing.T) {
+	a := assert.New(t)
+
+	agProgram := ag.Default.Program
+	ptProgram := pt.Default.Program
+	rgProgram := rg.Default.Program
+	defer func() {
+		ag.Default.Program = agProgram
+		pt.Default.Program = ptProgram
+		rg.Default.Program = rgProgram
+	}()
+	ag.Default.Program = "xxx-does-not-exist"
+	pt.Default.Program = "xxx-does-not-exist"
+	rg.Default.Program = "xxx-does-not-exist"
+
+	driverNames, err := DetectAll(context.Background(), nil)
+	a.NoError(err)
+	a.Equal([]string{"grep", "native"}, driverNames)
+}
+
+func TestDetectAllNone(t *testing.T) {
+	a := assert.New(t)
+
+	driverNames, err := DetectAll(context.Background(), []string{})
+	a.ErrorIs(err, ErrNoWorkingDriver)
+	a.Empty(driverNames)
+}
+
+func TestDetectForBrokenExceptGrep(t *testing.T) {
+	a := assert.New(t)
+
+	agProgram := ag.Default.Program
+	ptProgram := pt.Default.Program
+	rgProgram := rg.Default.Program
+	defer func() {
+		ag.Default.Program = agProgram
+		pt.Default.Program = ptProgram
+		rg.Default.Program = rgProgram
+	}()
+	ag.Default.Program = "xxx-does-not-exist"
+	pt.Default.Program = "xxx-does-not-exist"
+	rg.Default.Program = "xxx-does-not-exist"
+
+	driverName, err := DetectFor(context.Background(), nil, searchfiles.SearchOptions{Regexp: true})
+	a.NoError(err)
+	a.Equal("grep", driverName)
+
+	driverName, err = DetectFor(context.Background(), nil, searchfiles.SearchOptions{Encoding: searchfiles.EncodingLatin1})
+	a.NoError(err)
+	a.Equal("native", driverName)
+}
+
+func TestDetectWithRequirements(t *testing.T) {
+	a := assert.New(t)
+
+	agProgram := ag.Default.Program
+	ptProgram := pt.Default.Program
+	defer func() {
+		ag.Default.Program = agProgram
+		pt.Default.Program = ptProgram
+	}()
+	ag.Default.Program = "xxx-does-not-exist"
+	pt.Default.Program = "xxx-does-not-exist"
+
+	driverName, err := DetectWithRequirements(context.Background(), nil, map[string]Requirement{
+		"rg": {MinVersion: searchfiles.Version{Major: 999}},
+	})
+	a.NoError(err)
+	a.Equal("grep", driverName)
+
+	driverName, err = DetectWithRequirements(context.Background(), nil, map[string]Requirement{
+		"rg":   {MinVersion: searchfiles.Version{Major: 999}},
+		"grep": {Flavor: "bsd"},
+	})
+	a.NoError(err)
+	a.Equal("native", driverName)
+
+	driverName, err = DetectWithRequirements(context.Background(), []string{"native"}, map[string]Requirement{
+		"native": {MinVersion: searchfiles.Version{Major: 1}},
+	})
+	a.ErrorIs(err, ErrNoWorkingDriver)
+	a.Equal("", driverName)
+}
+
+func TestDetectFastest(t *testing.T) {
+	a := assert.New(t)
+
+	cacheFile := filepath.Join(t.TempDir(), "cache", "detect.json")
+
+	options := BenchmarkOptions{Directory: "..", Query: "func", CacheFile: cacheFile}
+
+	driverName, err := DetectFastest(context.Background(), []string{"grep", "native"}, options)
+	a.NoError(err)
+	a.Contains([]string{"grep", "native"}, driverName)
+
+	decisions := readCache(cacheFile)
+	if a.Len(decisions, 1) {
+		for key, cached := range decisions {
+			a.Equal(driverName, cached)
+
+			// Changing the decision shows that it's read back rather than
+			// worked out again.
+			other := "grep"
+			if cached == "grep" {
+				other = "native"
+			}
+			a.NoError(writeCache(cacheFile, key, other))
+
+			driverName, err = DetectFastest(context.Background(), []string{"grep", "native"}, options)
+			a.NoError(err)
+			a.Equal(other, driverName)
+		}
+	}
+}
+
+func TestDetectFastestOutOfTime(t *testing.T) {
+	a := assert.New(t)
+
+	cacheFile := filepath.Join(t.TempDir(), "detect.json")
+
+	driverName, err := DetectFastest(context.Background(), []string{"grep", "native"}, BenchmarkOptions{Directory: "..", Budget: time.Nanosecond, CacheFile: cacheFile})
+	a.ErrorIs(err, ErrBenchmarkTimeout)
+	a.Equal("", driverName)
+	a.NoFileExists(cacheFile)
+}
+
+func TestDetectFastestUnwritableCache(t *testing.T) {
+	a := assert.New(t)
+
+	// The cache file can't be created inside a file.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	driverName, err := DetectFastest(context.Background(), []string{"native"}, BenchmarkOptions{Directory: "..", Query: "func", CacheFile: filepath.Join(dir, "file", "detect.json")})
+	a.NoError(err)
+	a.Equal("native", driverName)
+}
+
+func TestDetectFastestNone(t *testing.T) {
+	a := assert.New(t)
+
+	driverName, err := DetectFastest(context.Background(), []string{}, BenchmarkOptions{Directory: ".."})
+	a.ErrorIs(err, ErrNoWorkingDriver)
+	a.Equal("", driverName)
+}
+
+func TestSample(t *testing.T) {
+	a := assert.New(t)
+
+	src := t.TempDir()
+	for name, data := range map[string]string{
+		"a-big.txt":     "too large to sample",
+		"a.txt":         "a",
+		".hidden/e.txt": "e",
+		"b/.hidden.txt": "f",
+		"b/c.txt":       "c",
+		"b/d.txt":       "d",
+		"b/g/j.txt":     "j",
+		"b/g/k.txt":     "k",
+		"z.txt":         "z",
+	} {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := t.TempDir()
+	a.NoError(sample(src, dst, 5, 10))
+
+	var copied []string
+	a.NoError(filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			rel, _ := filepath.Rel(dst, path)
+			copied = append(copied, filepath.ToSlash(rel))
+		}
+		return err
+	}))
+	a.Equal([]string{"a.txt", "b/c.txt", "b/d.txt", "b/g/j.txt", "b/g/k.txt"}, copied)
+}
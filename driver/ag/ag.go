@@ -5,11 +5,19 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"syscall"
 
 	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/capability"
+	"fknsrs.biz/p/searchfiles/internal/glob"
+	"fknsrs.biz/p/searchfiles/internal/matchline"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
 	"fknsrs.biz/p/searchfiles/internal/runctx"
+	"fknsrs.biz/p/searchfiles/internal/sniff"
+	"fknsrs.biz/p/searchfiles/internal/version"
 )
 
 var (
@@ -25,8 +33,16 @@ const (
 	DefaultProgram = "ag"
 )
 
+// versionFlavors is how ag says what version it is.
+var versionFlavors = []version.Flavor{
+	{Pattern: regexp.MustCompile(`ag version (\d+(?:\.\d+)*)`)},
+}
+
 type Driver struct {
 	Program string
+	// Decompress makes searches look at the decompressed contents of
+	// compressed files, with ag --search-zip.
+	Decompress bool
 }
 
 func (d *Driver) program() string {
@@ -44,59 +60,453 @@ func (d *Driver) SelfTest(ctx context.Context) error {
 	return nil
 }
 
-func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
-	files, err := d.search(ctx, "--literal", query, directory)
+func (d *Driver) Version(ctx context.Context) (searchfiles.Version, error) {
+	v, err := version.Probe(ctx, d.program(), versionFlavors)
+	if err != nil {
+		return searchfiles.Version{}, fmt.Errorf("ag.Driver.Version: %w", err)
+	}
+
+	return v, nil
+}
+
+// Capabilities reads ag --help to find out what the installed version can
+// do.
+func (d *Driver) Capabilities(ctx context.Context) (searchfiles.Capabilities, error) {
+	c, err := capability.Cached(ctx, d.program(), func(ctx context.Context) (searchfiles.Capabilities, error) {
+		return capability.Help(ctx, d.program(), searchfiles.CapabilityRegexp|searchfiles.CapabilityInvert, map[searchfiles.Capabilities][]string{
+			searchfiles.CapabilityMultiline:  {"--multiline"},
+			searchfiles.CapabilityBinary:     {"--search-binary"},
+			searchfiles.CapabilityVCSIgnore:  {"--skip-vcs-ignores"},
+			searchfiles.CapabilityDecompress: {"--search-zip"},
+		})
+	})
 	if err != nil {
+		return 0, fmt.Errorf("ag.Driver.Capabilities: %w", err)
+	}
+
+	return c, nil
+}
+
+func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	var files []string
+
+	if err := d.StreamLiteral(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("ag.Driver.SearchLiteral: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
 func (d *Driver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
-	files, err := d.search(ctx, query, directory)
-	if err != nil {
+	var files []string
+
+	if err := d.StreamRegexp(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("ag.Driver.SearchRegexp: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
-func (d *Driver) search(ctx context.Context, args ...string) ([]string, error) {
-	files, err := runctx.Run(ctx, d.program(), append([]string{"--files-with-matches"}, args...), func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 0 {
-					return nil
-				}
+func (d *Driver) StreamLiteral(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{}, fn); err != nil {
+		return fmt.Errorf("ag.Driver.StreamLiteral: %w", err)
+	}
 
-				if status.ExitStatus() == 1 && stdout.Len() == 0 && stderr.Len() == 0 {
-					return nil
-				}
+	return nil
+}
+
+func (d *Driver) StreamRegexp(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true}, fn); err != nil {
+		return fmt.Errorf("ag.Driver.StreamRegexp: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions, fn searchfiles.StreamFunc) error {
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return fmt.Errorf("ag.Driver.StreamWithOptions: %w", err)
+	}
+
+	list := "--files-with-matches"
+	if options.FilesWithoutMatch {
+		list = "--files-without-matches"
+	}
+
+	args = append([]string{list, "--null"}, args...)
+
+	if err := runctx.StreamSplit(ctx, "", d.program(), append(args, directory), runctx.NUL, checkError, func(line string) error {
+		file := cleanResult(directory, line)
+		if file == "" || skip(file) {
+			return nil
+		}
+		// Binary files count as having no matches, rather than being
+		// skipped like they are everywhere else.
+		if options.FilesWithoutMatch && !options.Binary {
+			if binary, err := sniff.File(filepath.Join(directory, file)); err != nil {
+				return fmt.Errorf("could not check file: %w", err)
+			} else if binary {
+				return nil
 			}
 		}
+		return fn(file)
+	}); err != nil {
+		return fmt.Errorf("ag.Driver.StreamWithOptions: could not run command: %w", err)
+	}
 
-		return err
-	})
+	return nil
+}
+
+func (d *Driver) MatchLiteral(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("ag.Driver.search: could not run command: %w", err)
+		return nil, fmt.Errorf("ag.Driver.MatchLiteral: %w", err)
 	}
 
-	return files, nil
+	return matches, nil
 }
 
-func cleanResults(directory string, input []string) []string {
-	var files []string
+func (d *Driver) MatchRegexp(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true})
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.MatchRegexp: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (d *Driver) MatchWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]searchfiles.Match, error) {
+	// ag prints each line of a multiline match on its own, with nothing to
+	// say which ones belong together.
+	if options.Multiline {
+		return nil, fmt.Errorf("ag.Driver.MatchWithOptions: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
 
-	for _, e := range input {
-		e = strings.TrimSpace(e)
-		e = strings.TrimPrefix(e, directory)
-		if e == "" {
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.MatchWithOptions: %w", err)
+	}
+
+	args = append([]string{"--nogroup", "--nocolor", "--filename", "--numbers", "--null"}, args...)
+
+	lines, err := d.lines(ctx, append(args, directory))
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.MatchWithOptions: could not run command: %w", err)
+	}
+
+	// Submatches are found by re-running the query with Go's regexp package.
+	// If it's not valid there, we still report the matching lines.
+	re, _ := pattern.Compile(query, options)
+	if options.Invert {
+		re = nil
+	}
+
+	matches, err := matchline.ParseAll(directory, lines, false, re)
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.MatchWithOptions: could not parse output: %w", err)
+	}
+
+	return filterMatches(skip, matches), nil
+}
+
+func (d *Driver) MatchWithContext(ctx context.Context, directory, query string, before, after int, options searchfiles.SearchOptions) ([]searchfiles.ContextMatch, error) {
+	if options.Multiline {
+		return nil, fmt.Errorf("ag.Driver.MatchWithContext: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.MatchWithContext: %w", err)
+	}
+
+	args = append([]string{"--nogroup", "--nocolor", "--filename", "--numbers", "--null", fmt.Sprintf("--before=%d", before), fmt.Sprintf("--after=%d", after)}, args...)
+
+	lines, err := d.lines(ctx, append(args, directory))
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.MatchWithContext: could not run command: %w", err)
+	}
+
+	re, _ := pattern.Compile(query, options)
+	if options.Invert {
+		re = nil
+	}
+
+	b := matchline.NewContextBuilder(before, after)
+
+	for _, line := range lines {
+		// Groups of lines that aren't next to each other are separated by
+		// lines of "--".
+		if line == "--" {
 			continue
 		}
 
-		files = append(files, e)
+		m, isContext, err := matchline.ParseContext(directory, line, false, re)
+		if err != nil {
+			return nil, fmt.Errorf("ag.Driver.MatchWithContext: could not parse output: %w", err)
+		}
+
+		switch {
+		case skip(m.Path):
+		case isContext:
+			b.Context(m.Path, m.LineNumber, m.Line)
+		default:
+			b.Match(m)
+		}
+	}
+
+	return b.Matches(), nil
+}
+
+func (d *Driver) CountWithOptions(ctx context.Context, directory, query string, mode searchfiles.CountMode, options searchfiles.SearchOptions) (searchfiles.Counts, error) {
+	if options.Multiline {
+		return nil, fmt.Errorf("ag.Driver.CountWithOptions: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	// ag's --count counts matches rather than lines, so lines are counted
+	// from the full output instead.
+	if mode != searchfiles.CountOccurrences || options.Invert {
+		matches, err := d.MatchWithOptions(ctx, directory, query, options)
+		if err != nil {
+			return nil, fmt.Errorf("ag.Driver.CountWithOptions: %w", err)
+		}
+
+		return matchline.Count(matches, false), nil
+	}
+
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("ag.Driver.CountWithOptions: %w", err)
+	}
+
+	args = append([]string{"--count", "--nocolor", "--filename", "--null"}, args...)
+
+	counts := searchfiles.Counts{}
+
+	if err := runctx.StreamSplit(ctx, "", d.program(), append(args, directory), runctx.PrefixedLines, checkError, func(line string) error {
+		file, n, err := matchline.ParseCount(directory, line)
+		if err != nil {
+			return fmt.Errorf("could not parse output: %w", err)
+		}
+
+		if n > 0 && !skip(file) {
+			counts[file] = n
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("ag.Driver.CountWithOptions: could not run command: %w", err)
+	}
+
+	return counts, nil
+}
+
+// lines runs ag and returns the lines it prints, each starting with a file
+// name ended by a NUL, as printed by --null.
+func (d *Driver) lines(ctx context.Context, args []string) ([]string, error) {
+	var lines []string
+
+	if err := runctx.StreamSplit(ctx, "", d.program(), args, runctx.PrefixedLines, checkError, func(line string) error {
+		if line != "" {
+			lines = append(lines, line)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("ag.Driver.lines: %w", err)
+	}
+
+	return lines, nil
+}
+
+func (d *Driver) searchArgs(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]string, func(file string) bool, error) {
+	args, err := filterArgs(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ag.Driver.searchArgs: %w", err)
+	}
+
+	ignoreArgs, err := ignoreArgs(options.Ignore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ag.Driver.searchArgs: %w", err)
+	}
+
+	queryArgs, err := queryArgs(query, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ag.Driver.searchArgs: %w", err)
+	}
+
+	// Not every glob can be passed on to ag, so the results are checked
+	// against all of them afterwards.
+	filter, err := glob.NewFilter(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ag.Driver.searchArgs: %w", err)
+	}
+
+	skip := func(file string) bool {
+		return filter.SkipPath(glob.Relative(file))
+	}
+
+	args = append(args, ignoreArgs...)
+
+	if d.Decompress {
+		c, err := d.Capabilities(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ag.Driver.searchArgs: %w", err)
+		}
+		if !c.Has(searchfiles.CapabilityDecompress) {
+			return nil, nil, fmt.Errorf("ag.Driver.searchArgs: searching compressed files: %w", searchfiles.ErrUnimplemented)
+		}
+		args = append(args, "--search-zip")
+	}
+
+	return append(args, queryArgs...), skip, nil
+}
+
+func filterArgs(options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	typeGlobs, err := glob.TypeGlobs(options.Types)
+	if err != nil {
+		return nil, fmt.Errorf("ag.filterArgs: %w", err)
+	}
+
+	// -G takes a single regexp, so it has to cover every file that might
+	// match. If any of the globs can't be expressed that way, it's left out.
+	include, err := glob.NameExpression(append(append([]string(nil), options.Include...), typeGlobs...))
+	if err != nil {
+		return nil, fmt.Errorf("ag.filterArgs: %w", err)
+	}
+	if include != "" {
+		args = append(args, "-G", include)
+	}
+
+	// --ignore applies to both files and directories, so directory-only globs
+	// are left to the filter.
+	for _, e := range options.Exclude {
+		if glob.IsBase(e) && !strings.HasSuffix(e, "/") {
+			args = append(args, "--ignore", e)
+		}
+	}
+
+	return args, nil
+}
+
+func ignoreArgs(policy searchfiles.IgnorePolicy) ([]string, error) {
+	var args []string
+
+	// ag can skip VCS ignore files or all of them (along with a lot of other
+	// things, using --unrestricted), but not just .ignore.
+	if policy.NoIgnoreFiles {
+		return nil, fmt.Errorf("ag.ignoreArgs: searching files excluded by .ignore: %w", searchfiles.ErrUnimplemented)
+	}
+	if policy.NoVCSIgnore {
+		args = append(args, "--skip-vcs-ignores")
+	}
+	if policy.Hidden {
+		args = append(args, "--hidden")
+	}
+
+	return args, nil
+}
+
+func queryArgs(query string, options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	// ag uses PCRE, which doesn't read every regexp the same way as RE2.
+	original := query
+	if options.Regexp {
+		var err error
+		if query, err = pattern.Translate(query, pattern.PCRE); err != nil {
+			return nil, fmt.Errorf("ag.queryArgs: %w", err)
+		}
+	}
+
+	// ag has no --line-regexp, so whole line matches are done by anchoring
+	// the query, which means it always has to be treated as a regexp. The
+	// same goes for multiline matches, as --literal looks for the query line
+	// by line.
+	if options.WholeLine || options.Multiline {
+		if !options.Regexp {
+			query = regexp.QuoteMeta(query)
+		}
+		if options.WholeLine {
+			query = "^(?:" + query + ")$"
+		}
+	} else if !options.Regexp {
+		args = append(args, "--literal")
+	}
+	// PCRE matches by byte unless UTF mode is turned on at the very start of
+	// the pattern.
+	if options.Regexp && pattern.NeedsUTF(original) {
+		query = pattern.UTFMode + query
+	}
+	// ag uses smart case by default, so case sensitivity has to be explicit.
+	// It's worked out from the query as it was given, since translating it
+	// can add upper case letters.
+	if pattern.ResolveCase([]string{original}, options).CaseInsensitive {
+		args = append(args, "--ignore-case")
+	} else {
+		args = append(args, "--case-sensitive")
 	}
+	if options.WholeWord && !options.WholeLine {
+		args = append(args, "--word-regexp")
+	}
+	if options.Invert {
+		if options.Multiline {
+			return nil, fmt.Errorf("ag.queryArgs: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+		}
+		args = append(args, "--invert-match")
+	}
+	// ag lets a regexp match across lines unless it's told not to.
+	if options.Multiline {
+		args = append(args, "--multiline")
+	} else {
+		args = append(args, "--nomultiline")
+	}
+	if options.Binary {
+		args = append(args, "--search-binary")
+	}
+	// ag searches bytes as they are, which is only right for UTF-8.
+	if options.Encoding != searchfiles.EncodingAuto && options.Encoding != searchfiles.EncodingUTF8 {
+		return nil, fmt.Errorf("ag.queryArgs: transcoding files: %w", searchfiles.ErrUnimplemented)
+	}
+
+	return append(args, query), nil
+}
+
+func filterMatches(skip func(file string) bool, matches []searchfiles.Match) []searchfiles.Match {
+	var a []searchfiles.Match
+
+	for _, e := range matches {
+		if !skip(e.Path) {
+			a = append(a, e)
+		}
+	}
+
+	return a
+}
+
+func checkError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.ExitStatus() == 0 {
+				return nil
+			}
+
+			if status.ExitStatus() == 1 && stderr.Len() == 0 {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
 
-	return files
+func cleanResult(directory, line string) string {
+	return strings.TrimPrefix(line, directory)
 }
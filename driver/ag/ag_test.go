@@ -10,6 +10,10 @@ func TestShared(t *testing.T) {
 	tests.Test_All(Default, t)
 }
 
+func TestDecompress(t *testing.T) {
+	tests.Test_Decompress(&Driver{Decompress: true}, t)
+}
+
 func BenchmarkShared(b *testing.B) {
 	tests.Benchmark_All(Default, b)
 }
@@ -4,12 +4,24 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"syscall"
 
 	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/capability"
+	"fknsrs.biz/p/searchfiles/internal/glob"
+	"fknsrs.biz/p/searchfiles/internal/ignore"
+	"fknsrs.biz/p/searchfiles/internal/matchline"
+	"fknsrs.biz/p/searchfiles/internal/multi"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
 	"fknsrs.biz/p/searchfiles/internal/runctx"
+	"fknsrs.biz/p/searchfiles/internal/sniff"
+	"fknsrs.biz/p/searchfiles/internal/version"
 )
 
 var (
@@ -25,6 +37,14 @@ const (
 	DefaultProgram = "grep"
 )
 
+// versionFlavors are the programs that might be installed as grep, and how
+// each of them says what version it is.
+var versionFlavors = []version.Flavor{
+	{Name: "gnu", Pattern: regexp.MustCompile(`\(GNU grep\) (\d+(?:\.\d+)*)`)},
+	{Name: "bsd", Pattern: regexp.MustCompile(`\(BSD grep[^)]*\) (\d+(?:\.\d+)*)`)},
+	{Name: "busybox", Pattern: regexp.MustCompile(`BusyBox v(\d+(?:\.\d+)*)`)},
+}
+
 type Driver struct {
 	Program string
 }
@@ -44,55 +64,630 @@ func (d *Driver) SelfTest(ctx context.Context) error {
 	return nil
 }
 
-func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
-	files, err := d.search(ctx, "--fixed-strings", query, directory)
+func (d *Driver) Version(ctx context.Context) (searchfiles.Version, error) {
+	v, err := version.Probe(ctx, d.program(), versionFlavors)
+	if err != nil {
+		return searchfiles.Version{}, fmt.Errorf("grep.Driver.Version: %w", err)
+	}
+
+	return v, nil
+}
+
+// Capabilities reads grep --help to find out what the installed version can
+// do. Ignore files are handled here rather than by grep, so they're always
+// supported.
+func (d *Driver) Capabilities(ctx context.Context) (searchfiles.Capabilities, error) {
+	c, err := capability.Cached(ctx, d.program(), func(ctx context.Context) (searchfiles.Capabilities, error) {
+		c, err := capability.Help(ctx, d.program(), searchfiles.CapabilityInvert|searchfiles.CapabilityVCSIgnore, map[searchfiles.Capabilities][]string{
+			searchfiles.CapabilityRegexp:    {"--perl-regexp"},
+			searchfiles.CapabilityMultiline: {"--perl-regexp", "--null-data"},
+			searchfiles.CapabilityBinary:    {"--text"},
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		// GNU grep lists --perl-regexp even when it's built without PCRE,
+		// and only complains once it's used.
+		if c.Has(searchfiles.CapabilityRegexp) {
+			if _, err := runctx.Run(ctx, d.program(), []string{"--perl-regexp", "--quiet", "x", os.DevNull}, checkError); err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return 0, ctxErr
+				}
+
+				c &^= searchfiles.CapabilityRegexp | searchfiles.CapabilityMultiline
+			}
+		}
+
+		return c, nil
+	})
 	if err != nil {
+		return 0, fmt.Errorf("grep.Driver.Capabilities: %w", err)
+	}
+
+	return c, nil
+}
+
+func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	var files []string
+
+	if err := d.StreamLiteral(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("grep.Driver.SearchLiteral: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
 func (d *Driver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
-	files, err := d.search(ctx, "--perl-regexp", query, directory)
-	if err != nil {
+	var files []string
+
+	if err := d.StreamRegexp(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("grep.Driver.SearchRegexp: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
-func (d *Driver) search(ctx context.Context, args ...string) ([]string, error) {
-	files, err := runctx.Run(ctx, d.program(), append([]string{"--recursive", "--files-with-matches"}, args...), func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 0 || status.ExitStatus() == 1 {
-					return nil
-				}
+func (d *Driver) StreamLiteral(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{}, fn); err != nil {
+		return fmt.Errorf("grep.Driver.StreamLiteral: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamRegexp(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true}, fn); err != nil {
+		return fmt.Errorf("grep.Driver.StreamRegexp: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions, fn searchfiles.StreamFunc) error {
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return fmt.Errorf("grep.Driver.StreamWithOptions: %w", err)
+	}
+
+	list := "--files-with-matches"
+	if options.FilesWithoutMatch {
+		list = "--files-without-match"
+	}
+
+	args = append([]string{"--recursive", list, "--null"}, args...)
+
+	if err := runctx.StreamSplit(ctx, directory, d.program(), append(args, "."), runctx.NUL, checkError, func(line string) error {
+		file := cleanResult(line)
+		if file == "" || skip(file) {
+			return nil
+		}
+		// Binary files count as having no matches, rather than being
+		// skipped like they are everywhere else, and with --null-data, grep
+		// doesn't see NULs as a sign of a binary file at all.
+		if (options.FilesWithoutMatch || options.Multiline) && !options.Binary {
+			if binary, err := sniff.File(filepath.Join(directory, file)); err != nil {
+				return fmt.Errorf("could not check file: %w", err)
+			} else if binary {
+				return nil
 			}
 		}
+		return fn(file)
+	}); err != nil {
+		return fmt.Errorf("grep.Driver.StreamWithOptions: could not run command: %w", err)
+	}
 
-		return err
-	})
+	return nil
+}
+
+func (d *Driver) MatchLiteral(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("grep.Driver.search: could not run command: %w", err)
+		return nil, fmt.Errorf("grep.Driver.MatchLiteral: %w", err)
 	}
 
-	return files, nil
+	return matches, nil
 }
 
-func cleanResults(directory string, input []string) []string {
-	var files []string
+func (d *Driver) MatchRegexp(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true})
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.MatchRegexp: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (d *Driver) MatchWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]searchfiles.Match, error) {
+	// With --null-data, grep only knows that a whole file matched.
+	if options.Multiline {
+		return nil, fmt.Errorf("grep.Driver.MatchWithOptions: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.MatchWithOptions: %w", err)
+	}
+
+	args = append([]string{"--recursive", "--with-filename", "--line-number", "--byte-offset", "--null"}, args...)
 
-	for _, e := range input {
-		e = strings.TrimSpace(e)
-		e = strings.TrimPrefix(e, directory)
-		if e == "" {
+	lines, err := d.lines(ctx, directory, append(args, "."))
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.MatchWithOptions: could not run command: %w", err)
+	}
+
+	// Submatches are found by re-running the query with Go's regexp package.
+	// If it's not valid there, we still report the matching lines.
+	re, _ := pattern.Compile(query, options)
+	if options.Invert {
+		re = nil
+	}
+
+	matches, err := matchline.ParseAll(".", lines, true, re)
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.MatchWithOptions: could not parse output: %w", err)
+	}
+
+	return filterMatches(skip, matches), nil
+}
+
+func (d *Driver) MatchWithContext(ctx context.Context, directory, query string, before, after int, options searchfiles.SearchOptions) ([]searchfiles.ContextMatch, error) {
+	if options.Multiline {
+		return nil, fmt.Errorf("grep.Driver.MatchWithContext: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.MatchWithContext: %w", err)
+	}
+
+	args = append([]string{"--recursive", "--with-filename", "--line-number", "--byte-offset", "--null", fmt.Sprintf("--before-context=%d", before), fmt.Sprintf("--after-context=%d", after)}, args...)
+
+	lines, err := d.lines(ctx, directory, append(args, "."))
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.MatchWithContext: could not run command: %w", err)
+	}
+
+	re, _ := pattern.Compile(query, options)
+	if options.Invert {
+		re = nil
+	}
+
+	b := matchline.NewContextBuilder(before, after)
+
+	for _, line := range lines {
+		// Groups of lines that aren't next to each other are separated by
+		// lines of "--".
+		if line == "--" {
 			continue
 		}
 
-		files = append(files, e)
+		m, isContext, err := matchline.ParseContext(".", line, true, re)
+		if err != nil {
+			return nil, fmt.Errorf("grep.Driver.MatchWithContext: could not parse output: %w", err)
+		}
+
+		switch {
+		case skip(m.Path):
+		case isContext:
+			b.Context(m.Path, m.LineNumber, m.Line)
+		default:
+			b.Match(m)
+		}
+	}
+
+	return b.Matches(), nil
+}
+
+func (d *Driver) CountWithOptions(ctx context.Context, directory, query string, mode searchfiles.CountMode, options searchfiles.SearchOptions) (searchfiles.Counts, error) {
+	if options.Multiline {
+		return nil, fmt.Errorf("grep.Driver.CountWithOptions: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return nil, fmt.Errorf("grep.Driver.CountWithOptions: %w", err)
+	}
+
+	counts := searchfiles.Counts{}
+
+	// grep can only count lines, but --only-matching prints each match on a
+	// line of its own, so occurrences are counted from that.
+	if mode == searchfiles.CountOccurrences && !options.Invert {
+		args = append([]string{"--recursive", "--only-matching", "--with-filename", "--line-number", "--null"}, args...)
+
+		if err := runctx.StreamSplit(ctx, directory, d.program(), append(args, "."), runctx.PrefixedLines, checkError, func(line string) error {
+			m, err := matchline.Parse(".", line, false, nil)
+			if err != nil {
+				return fmt.Errorf("could not parse output: %w", err)
+			}
+
+			if !skip(m.Path) {
+				counts[m.Path]++
+			}
+
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("grep.Driver.CountWithOptions: could not run command: %w", err)
+		}
+
+		return counts, nil
+	}
+
+	args = append([]string{"--recursive", "--count", "--with-filename", "--null"}, args...)
+
+	if err := runctx.StreamSplit(ctx, directory, d.program(), append(args, "."), runctx.PrefixedLines, checkError, func(line string) error {
+		file, n, err := matchline.ParseCount(".", line)
+		if err != nil {
+			return fmt.Errorf("could not parse output: %w", err)
+		}
+
+		// grep prints a count for every file it searches, even if it's zero.
+		if n > 0 && !skip(file) {
+			counts[file] = n
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("grep.Driver.CountWithOptions: could not run command: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (d *Driver) StreamMulti(ctx context.Context, directory string, queries []string, mode searchfiles.MultiMode, options searchfiles.SearchOptions, fn searchfiles.MultiFunc) error {
+	if options.Invert {
+		return fmt.Errorf("grep.Driver.StreamMulti: inverted matching: %w", searchfiles.ErrUnimplemented)
+	}
+	if options.Multiline {
+		return fmt.Errorf("grep.Driver.StreamMulti: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	// Smart case has to be settled before any arguments are worked out, so
+	// that grep and the collector agree on it.
+	options = pattern.ResolveCase(queries, options)
+
+	set, err := multi.Compile(queries, options)
+	if err != nil {
+		return fmt.Errorf("grep.Driver.StreamMulti: could not compile queries: %w", err)
+	}
+
+	if len(queries) == 0 {
+		return nil
 	}
 
-	return files
+	args, skip, err := searchArgs(directory, nil, options)
+	if err != nil {
+		return fmt.Errorf("grep.Driver.StreamMulti: %w", err)
+	}
+
+	patternArgs, cleanup, err := patternArgs(queries, options)
+	if err != nil {
+		return fmt.Errorf("grep.Driver.StreamMulti: %w", err)
+	}
+	defer cleanup()
+
+	args = append(append([]string{"--recursive", "--with-filename", "--line-number", "--null"}, args...), patternArgs...)
+
+	// grep can only tell us which lines matched, not which patterns they
+	// matched, so that's worked out again from the lines.
+	c := multi.NewCollector(set, mode, fn)
+
+	if err := runctx.StreamSplit(ctx, directory, d.program(), append(args, "."), runctx.PrefixedLines, checkError, func(line string) error {
+		m, err := matchline.Parse(".", line, false, nil)
+		if err != nil {
+			return fmt.Errorf("could not parse output: %w", err)
+		}
+		if skip(m.Path) {
+			return nil
+		}
+		return c.Line(m.Path, m.Line)
+	}); err != nil {
+		return fmt.Errorf("grep.Driver.StreamMulti: could not run command: %w", err)
+	}
+
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("grep.Driver.StreamMulti: %w", err)
+	}
+
+	return nil
+}
+
+// lines runs grep in directory and returns the lines it prints, each starting
+// with a file name ended by a NUL, as printed by --null.
+func (d *Driver) lines(ctx context.Context, directory string, args []string) ([]string, error) {
+	var lines []string
+
+	if err := runctx.StreamSplit(ctx, directory, d.program(), args, runctx.PrefixedLines, checkError, func(line string) error {
+		if line != "" {
+			lines = append(lines, line)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("grep.Driver.lines: %w", err)
+	}
+
+	return lines, nil
+}
+
+func searchArgs(directory string, queries []string, options searchfiles.SearchOptions) ([]string, func(file string) bool, error) {
+	args, err := filterArgs(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("grep.searchArgs: %w", err)
+	}
+
+	ignoreArgs, err := ignoreArgs(options.Ignore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("grep.searchArgs: %w", err)
+	}
+
+	queryArgs, err := queryArgs(queries, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("grep.searchArgs: %w", err)
+	}
+
+	// Not every glob can be passed on to grep, so the results are checked
+	// against all of them afterwards, along with any ignore files.
+	filter, err := glob.NewFilter(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("grep.searchArgs: %w", err)
+	}
+
+	ignores := ignore.New(directory, options.Ignore)
+
+	skip := func(file string) bool {
+		name := glob.Relative(file)
+		return filter.SkipPath(name) || ignores.SkipPath(name)
+	}
+
+	args = append(args, ignoreArgs...)
+
+	// Ignored directories are still checked for afterwards, but those that
+	// can be named to grep aren't searched at all.
+	for _, e := range ignores.DirGlobs() {
+		args = append(args, "--exclude-dir="+e)
+	}
+
+	return append(args, queryArgs...), skip, nil
+}
+
+func filterArgs(options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	typeGlobs, err := glob.TypeGlobs(options.Types)
+	if err != nil {
+		return nil, fmt.Errorf("grep.filterArgs: %w", err)
+	}
+
+	// --include only looks at file names, and it has to either cover every
+	// file that might match or not be used at all.
+	include := append(append([]string(nil), options.Include...), typeGlobs...)
+	if allBase(include) {
+		for _, e := range include {
+			args = append(args, "--include="+e)
+		}
+	}
+
+	for _, e := range options.Exclude {
+		if !glob.IsBase(e) {
+			continue
+		}
+
+		if !strings.HasSuffix(e, "/") {
+			args = append(args, "--exclude="+e)
+		}
+		args = append(args, "--exclude-dir="+strings.TrimSuffix(e, "/"))
+	}
+
+	return args, nil
+}
+
+func allBase(globs []string) bool {
+	for _, e := range globs {
+		if !glob.IsBase(e) || strings.HasSuffix(e, "/") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ignoreArgs(policy searchfiles.IgnorePolicy) ([]string, error) {
+	var args []string
+
+	// grep doesn't know about ignore files, so those are handled by the
+	// matcher, but hidden files can at least be skipped up front. grep
+	// matches --exclude-dir against the end of every directory it's given,
+	// so it's run in the searched directory and given ".", which the pattern
+	// doesn't match, rather than a path that might be inside a hidden
+	// directory.
+	if !policy.Hidden {
+		args = append(args, "--exclude=.*", "--exclude-dir=.[!.]*")
+	}
+
+	return args, nil
+}
+
+func queryArgs(queries []string, options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	switch {
+	case options.Multiline:
+		// With --null-data, grep reads a file without NULs in it as a single
+		// line, so a Perl regexp can match across lines. Case and whole line
+		// or word matching are worked into the regexp, since the flags for
+		// them would apply to the whole file.
+		args = append(args, "--perl-regexp", "--null-data")
+	case options.Regexp:
+		args = append(args, "--perl-regexp")
+	default:
+		args = append(args, "--fixed-strings")
+	}
+	if !options.Multiline {
+		// grep has no equivalent of --smart-case, so we work it out
+		// ourselves.
+		if pattern.ResolveCase(queries, options).CaseInsensitive {
+			args = append(args, "--ignore-case")
+		}
+		if options.WholeLine {
+			args = append(args, "--line-regexp")
+		} else if options.WholeWord {
+			args = append(args, "--word-regexp")
+		}
+	}
+	if options.Invert {
+		if options.Multiline {
+			return nil, fmt.Errorf("grep.queryArgs: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+		}
+		args = append(args, "--invert-match")
+	}
+	// Left to itself, grep reports binary files as matching without saying
+	// where, so they're either searched as text or not at all.
+	if options.Binary {
+		args = append(args, "--text")
+	} else {
+		args = append(args, "--binary-files=without-match")
+	}
+	// grep searches bytes as they are, which is only right for UTF-8.
+	if options.Encoding != searchfiles.EncodingAuto && options.Encoding != searchfiles.EncodingUTF8 {
+		return nil, fmt.Errorf("grep.queryArgs: transcoding files: %w", searchfiles.ErrUnimplemented)
+	}
+
+	if options.Multiline {
+		expressions := make([]string, len(queries))
+		for i, query := range queries {
+			expressions[i] = pattern.Expression(query, pattern.ResolveCase(queries, options))
+		}
+		queries = expressions
+		options.Regexp = true
+	}
+
+	queries, utf, err := translate(queries, options)
+	if err != nil {
+		return nil, fmt.Errorf("grep.queryArgs: %w", err)
+	}
+
+	for _, query := range queries {
+		if utf {
+			query = pattern.UTFMode + query
+		}
+		args = append(args, "--regexp="+query)
+	}
+
+	return args, nil
+}
+
+// translate rewrites regexp queries in the syntax of --perl-regexp, so they
+// match what they would anywhere else. That also spells out any line breaks
+// in them, which would otherwise split them in two. It also reports whether
+// they have to be run in UTF mode, which has to be turned on at the very
+// start of the pattern grep is given.
+func translate(queries []string, options searchfiles.SearchOptions) ([]string, bool, error) {
+	if !options.Regexp {
+		return queries, false, nil
+	}
+
+	translated := make([]string, len(queries))
+	utf := false
+	for i, query := range queries {
+		var err error
+		if translated[i], err = pattern.Translate(query, pattern.PCRE); err != nil {
+			return nil, false, fmt.Errorf("grep.translate: %w", err)
+		}
+		utf = utf || pattern.NeedsUTF(query)
+	}
+
+	return translated, utf, nil
+}
+
+// patternFileThreshold is the number of patterns beyond which they're
+// written to a file and passed with --file, rather than one by one with
+// --regexp.
+const patternFileThreshold = 32
+
+func patternArgs(queries []string, options searchfiles.SearchOptions) ([]string, func(), error) {
+	queries, utf, err := translate(queries, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("grep.patternArgs: %w", err)
+	}
+
+	// --perl-regexp only accepts a single pattern, so regexps are combined
+	// into one.
+	if options.Regexp && len(queries) > 1 {
+		parts := make([]string, len(queries))
+		for i, query := range queries {
+			parts[i] = "(?:" + query + ")"
+		}
+		queries = []string{strings.Join(parts, "|")}
+	}
+	if utf {
+		for i, query := range queries {
+			queries[i] = pattern.UTFMode + query
+		}
+	}
+
+	if len(queries) <= patternFileThreshold {
+		var args []string
+		for _, query := range queries {
+			args = append(args, "--regexp="+query)
+		}
+
+		return args, func() {}, nil
+	}
+
+	fd, err := os.CreateTemp("", "searchfiles-patterns-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("grep.patternArgs: could not create pattern file: %w", err)
+	}
+
+	cleanup := func() { os.Remove(fd.Name()) }
+
+	if _, err := io.WriteString(fd, strings.Join(queries, "\n")+"\n"); err != nil {
+		fd.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("grep.patternArgs: could not write pattern file: %w", err)
+	}
+
+	if err := fd.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("grep.patternArgs: could not close pattern file: %w", err)
+	}
+
+	return []string{"--file=" + fd.Name()}, cleanup, nil
+}
+
+func filterMatches(skip func(file string) bool, matches []searchfiles.Match) []searchfiles.Match {
+	var a []searchfiles.Match
+
+	for _, e := range matches {
+		if !skip(e.Path) {
+			a = append(a, e)
+		}
+	}
+
+	return a
+}
+
+func checkError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.ExitStatus() == 0 || status.ExitStatus() == 1 {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// cleanResult turns a file name printed by grep, which is run in the searched
+// directory, into one relative to that directory.
+func cleanResult(line string) string {
+	return strings.TrimPrefix(line, ".")
 }
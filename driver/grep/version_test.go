@@ -0,0 +1,26 @@
+package grep
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/version"
+)
+
+func TestVersionFlavors(t *testing.T) {
+	a := assert.New(t)
+
+	for output, expected := range map[string]searchfiles.Version{
+		"grep (GNU grep) 3.8\nCopyright (C) 2022 Free Software Foundation, Inc.":                 {Flavor: "gnu", Major: 3, Minor: 8},
+		"grep (BSD grep, GNU compatible) 2.6.0-FreeBSD":                                          {Flavor: "bsd", Major: 2, Minor: 6},
+		"grep (BSD grep) 2.5.1-FreeBSD":                                                          {Flavor: "bsd", Major: 2, Minor: 5, Patch: 1},
+		"grep: unrecognized option '--version'\nBusyBox v1.36.1 (2023-06-14) multi-call binary.": {Flavor: "busybox", Major: 1, Minor: 36, Patch: 1},
+	} {
+		v, err := version.Find(output, versionFlavors)
+		if a.NoError(err, output) {
+			a.Equal(expected, v, output)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package native
+
+// ahoCorasick finds any number of literal byte strings in a single pass over
+// its input. It's a trie of the patterns, where each node also has a failure
+// link to the longest proper suffix of its path that's also in the trie.
+type ahoCorasick struct {
+	next []map[byte]int32
+	fail []int32
+	// out holds the patterns ending at each node, including those reached
+	// by following failure links.
+	out [][]int
+	// empty holds the patterns that are empty, and so match anything.
+	empty []int
+}
+
+func newAhoCorasick(patterns [][]byte) *ahoCorasick {
+	ac := &ahoCorasick{
+		next: []map[byte]int32{{}},
+		fail: []int32{0},
+		out:  [][]int{nil},
+	}
+
+	for i, p := range patterns {
+		if len(p) == 0 {
+			ac.empty = append(ac.empty, i)
+			continue
+		}
+
+		var state int32
+		for _, c := range p {
+			n, ok := ac.next[state][c]
+			if !ok {
+				n = int32(len(ac.next))
+				ac.next = append(ac.next, map[byte]int32{})
+				ac.fail = append(ac.fail, 0)
+				ac.out = append(ac.out, nil)
+				ac.next[state][c] = n
+			}
+			state = n
+		}
+
+		ac.out[state] = append(ac.out[state], i)
+	}
+
+	// Failure links are set breadth first, so a node's link is always to a
+	// node that's already been finished.
+	queue := make([]int32, 0, len(ac.next))
+	for _, n := range ac.next[0] {
+		queue = append(queue, n)
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for c, n := range ac.next[state] {
+			f := ac.fail[state]
+			for {
+				if m, ok := ac.next[f][c]; ok {
+					ac.fail[n] = m
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = ac.fail[f]
+			}
+
+			ac.out[n] = append(ac.out[n], ac.out[ac.fail[n]]...)
+			queue = append(queue, n)
+		}
+	}
+
+	return ac
+}
+
+// step moves from state past c.
+func (ac *ahoCorasick) step(state int32, c byte) int32 {
+	for {
+		if n, ok := ac.next[state][c]; ok {
+			return n
+		}
+		if state == 0 {
+			return 0
+		}
+		state = ac.fail[state]
+	}
+}
+
+// scan feeds b through the automaton starting from state, marking every
+// pattern it finds in found. It returns the state to continue from with the
+// next block of input and the number of patterns that weren't marked before.
+func (ac *ahoCorasick) scan(state int32, b []byte, found []bool) (int32, int) {
+	n := 0
+
+	for _, c := range b {
+		state = ac.step(state, c)
+
+		for _, i := range ac.out[state] {
+			if !found[i] {
+				found[i] = true
+				n++
+			}
+		}
+	}
+
+	return state, n
+}
@@ -0,0 +1,41 @@
+package native
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAhoCorasick(t *testing.T) {
+	a := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		patterns []string
+		input    []string
+		found    []bool
+	}{
+		{"None", []string{"he", "she"}, []string{"xyz"}, []bool{false, false}},
+		{"Overlapping", []string{"he", "she", "his", "hers"}, []string{"ushers"}, []bool{true, true, false, true}},
+		{"Suffix", []string{"abcd", "bc"}, []string{"xabcx"}, []bool{false, true}},
+		{"Boundary", []string{"needle", "haystack"}, []string{"nee", "dle hay", "st", "ack"}, []bool{true, true}},
+		{"Repeated", []string{"aab"}, []string{"aaaab"}, []bool{true}},
+		{"Duplicate", []string{"x", "x"}, []string{"x"}, []bool{true, true}},
+	} {
+		var patterns [][]byte
+		for _, p := range tc.patterns {
+			patterns = append(patterns, []byte(p))
+		}
+
+		ac := newAhoCorasick(patterns)
+
+		found := make([]bool, len(patterns))
+
+		var state int32
+		for _, e := range tc.input {
+			state, _ = ac.scan(state, []byte(e), found)
+		}
+
+		a.Equal(tc.found, found, tc.name)
+	}
+}
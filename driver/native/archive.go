@@ -0,0 +1,230 @@
+package native
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+const (
+	DefaultArchiveDepth = 3
+	DefaultArchiveSize  = 1 << 30
+)
+
+// ErrArchiveTooLarge is what Driver.Skipped is called with when searching an
+// archive would mean reading more than the driver's ArchiveSize out of it.
+var ErrArchiveTooLarge = errors.New("archive too large")
+
+type archiveKind int
+
+const (
+	notArchive archiveKind = iota
+	zipArchive
+	tarArchive
+	tarGzArchive
+	gzipFile
+)
+
+// archiveKindOf works out what sort of archive a file is from its name.
+func archiveKindOf(name string) archiveKind {
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return zipArchive
+	case strings.HasSuffix(name, ".tar"):
+		return tarArchive
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return tarGzArchive
+	case strings.HasSuffix(name, ".gz"):
+		return gzipFile
+	default:
+		return notArchive
+	}
+}
+
+// archiveLimits bounds how far a search goes into archives.
+type archiveLimits struct {
+	// depth is how many archives deep to look.
+	depth int
+	// size is how many bytes can be read out of an archive, including any
+	// archives inside it.
+	size int64
+}
+
+// walk calls fn with each file in the archive read from rd, along with its
+// name in the form "name!/inner/path". Archives inside it are walked in turn
+// as far as l allows, and otherwise passed to fn like any other file.
+func (l *archiveLimits) walk(ctx context.Context, rd io.Reader, name string, fn func(rd io.Reader, name string) error) error {
+	remaining := l.size
+
+	if err := l.walkArchive(ctx, rd, name, 1, &remaining, fn); err != nil {
+		return fmt.Errorf("native.archiveLimits.walk: %w", err)
+	}
+
+	return nil
+}
+
+func (l *archiveLimits) walkArchive(ctx context.Context, rd io.Reader, name string, depth int, remaining *int64, fn func(rd io.Reader, name string) error) error {
+	member := func(rd io.Reader, inner string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rd = &limitReader{rd: &decodeReader{rd: rd}, remaining: remaining}
+		// Cleaning the name as if it were absolute stops it from climbing
+		// out of the archive with "..".
+		inner = name + "!" + path.Clean("/"+inner)
+
+		var err error
+		if depth < l.depth && archiveKindOf(inner) != notArchive {
+			err = l.walkArchive(ctx, rd, inner, depth+1, remaining, fn)
+		} else {
+			err = fn(rd, inner)
+		}
+
+		// Some searches treat a read error as the end of the file, so running
+		// out of room has to be checked for here as well.
+		if err == nil && *remaining < 0 {
+			err = &unreadableError{err: ErrArchiveTooLarge}
+		}
+		if err != nil {
+			return fmt.Errorf("%q: %w", inner, err)
+		}
+
+		return nil
+	}
+
+	switch archiveKindOf(name) {
+	case zipArchive:
+		return walkZip(rd, member)
+	case tarArchive:
+		return walkTar(rd, member)
+	case tarGzArchive, gzipFile:
+		br := bufio.NewReader(rd)
+		if magic, _ := br.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+			// It's not really compressed, so it's searched as it is.
+			return fn(br, name)
+		}
+
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("could not read %q: %w", name, &unreadableError{err: err})
+		}
+		defer zr.Close()
+
+		if archiveKindOf(name) == tarGzArchive {
+			return walkTar(zr, member)
+		}
+
+		return member(zr, strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	default:
+		return fn(rd, name)
+	}
+}
+
+func walkZip(rd io.Reader, member func(rd io.Reader, name string) error) error {
+	zr, err := openZip(rd)
+	if err != nil {
+		return fmt.Errorf("could not read zip archive: %w", &unreadableError{err: err})
+	}
+
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		fd, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("could not open %q in zip archive: %w", f.Name, &unreadableError{err: err})
+		}
+
+		err = member(fd, f.Name)
+		fd.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openZip reads the zip archive in rd, which has to be read into memory
+// unless it's a file that can be read at any offset.
+func openZip(rd io.Reader) (*zip.Reader, error) {
+	if f, ok := rd.(interface {
+		io.ReaderAt
+		Stat() (fs.FileInfo, error)
+	}); ok {
+		if info, err := f.Stat(); err == nil {
+			return zip.NewReader(f, info.Size())
+		}
+	}
+
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	return zip.NewReader(bytes.NewReader(b), int64(len(b)))
+}
+
+func walkTar(rd io.Reader, member func(rd io.Reader, name string) error) error {
+	tr := tar.NewReader(rd)
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("could not read tar archive: %w", &unreadableError{err: err})
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := member(tr, h.Name); err != nil {
+			return err
+		}
+	}
+}
+
+// limitReader fails with ErrArchiveTooLarge, as an unreadableError, once more
+// than *remaining bytes have been read through it. Everything read out of one archive shares the
+// same count.
+type limitReader struct {
+	rd        io.Reader
+	remaining *int64
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if *r.remaining < 0 {
+		return 0, &unreadableError{err: ErrArchiveTooLarge}
+	}
+
+	// Reading one byte more than what's left is enough to tell that there's
+	// too much.
+	if int64(len(p)) > *r.remaining+1 {
+		p = p[:*r.remaining+1]
+	}
+
+	n, err := r.rd.Read(p)
+	*r.remaining -= int64(n)
+
+	if *r.remaining < 0 {
+		return n, &unreadableError{err: ErrArchiveTooLarge}
+	}
+
+	return n, err
+}
@@ -0,0 +1,286 @@
+package native
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+type archiveFile struct {
+	name string
+	data []byte
+}
+
+func makeZip(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func makeTar(t *testing.T, files ...archiveFile) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func makeGzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func archiveFS(t *testing.T) fstest.MapFS {
+	needle := []byte("a leaked needle\n")
+	hay := []byte("just hay\n")
+
+	return fstest.MapFS{
+		"bundle.zip": {Data: makeZip(t,
+			archiveFile{"inner/leak.txt", needle},
+			archiveFile{"clean.txt", hay},
+			archiveFile{"../escape.txt", needle},
+		)},
+		"bundle.tar.gz": {Data: makeGzip(t, makeTar(t, archiveFile{"dir/leak.txt", needle}))},
+		"plain.tar":     {Data: makeTar(t, archiveFile{"a.txt", needle}, archiveFile{"b.txt", hay})},
+		"app.log.gz":    {Data: makeGzip(t, needle)},
+		"nested.zip":    {Data: makeZip(t, archiveFile{"inner.tar", makeTar(t, archiveFile{"deep.txt", needle})})},
+		"loose.txt":     {Data: needle},
+	}
+}
+
+func searchArchives(d *Driver, fsys fstest.MapFS, query string) ([]string, error) {
+	var results []string
+	err := d.StreamFS(context.Background(), fsys, query, searchfiles.SearchOptions{}, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	sort.Strings(results)
+
+	return results, err
+}
+
+func TestArchives(t *testing.T) {
+	a := assert.New(t)
+
+	fsys := archiveFS(t)
+
+	results, err := searchArchives(&Driver{Archives: true}, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{
+		"/app.log.gz!/app.log",
+		"/bundle.tar.gz!/dir/leak.txt",
+		"/bundle.zip!/escape.txt",
+		"/bundle.zip!/inner/leak.txt",
+		"/loose.txt",
+		"/nested.zip!/inner.tar!/deep.txt",
+		"/plain.tar!/a.txt",
+	}, results)
+
+	results, err = searchArchives(&Driver{}, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/loose.txt"}, results)
+}
+
+func TestArchivesDepth(t *testing.T) {
+	a := assert.New(t)
+
+	results, err := searchArchives(&Driver{Archives: true, ArchiveDepth: 1}, archiveFS(t), "needle")
+	a.NoError(err)
+	a.Contains(results, "/bundle.zip!/inner/leak.txt")
+	a.NotContains(results, "/nested.zip!/inner.tar!/deep.txt")
+
+	results, err = searchArchives(&Driver{Archives: true, ArchiveDepth: 2}, archiveFS(t), "needle")
+	a.NoError(err)
+	a.Contains(results, "/nested.zip!/inner.tar!/deep.txt")
+}
+
+func TestArchivesSize(t *testing.T) {
+	a := assert.New(t)
+
+	fsys := fstest.MapFS{
+		"bomb.gz":  {Data: makeGzip(t, append(bytes.Repeat([]byte("0"), 1<<20), "needle\n"...))},
+		"note.txt": {Data: []byte("a plain needle\n")},
+	}
+
+	var skipped []error
+	var mu sync.Mutex
+	d := &Driver{Archives: true, ArchiveSize: 1 << 10, Skipped: func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		a.Equal("/bomb.gz", name)
+		skipped = append(skipped, err)
+	}}
+
+	results, err := searchArchives(d, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/note.txt"}, results)
+	if a.Len(skipped, 1) {
+		a.ErrorIs(skipped[0], ErrArchiveTooLarge)
+	}
+
+	results, err = searchArchives(&Driver{Archives: true, ArchiveSize: 2 << 20}, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/bomb.gz!/bomb", "/note.txt"}, results)
+}
+
+func TestArchivesCorrupt(t *testing.T) {
+	a := assert.New(t)
+
+	tarball := makeTar(t, archiveFile{"inner.txt", bytes.Repeat([]byte("a tarred needle\n"), 100)})
+
+	// The member is stored with a compression method nothing knows about.
+	var unsupported bytes.Buffer
+	zw := zip.NewWriter(&unsupported)
+	w, err := zw.CreateRaw(&zip.FileHeader{Name: "inner.txt", Method: 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("a zipped needle\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"broken.zip":    {Data: []byte("PK\x03\x04 a needle that isn't a zip\n")},
+		"method.zip":    {Data: unsupported.Bytes()},
+		"truncated.tar": {Data: tarball[:700]},
+		"truncated.tgz": {Data: makeGzip(t, tarball)[:40]},
+		"note.txt":      {Data: []byte("a plain needle\n")},
+	}
+
+	var skipped []string
+	var mu sync.Mutex
+	d := &Driver{Archives: true, Skipped: func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		skipped = append(skipped, name)
+	}}
+
+	results, err := searchArchives(d, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/note.txt"}, results)
+	a.ElementsMatch([]string{"/broken.zip", "/method.zip", "/truncated.tar", "/truncated.tgz"}, skipped)
+}
+
+func TestArchivesMatch(t *testing.T) {
+	a := assert.New(t)
+
+	fsys := fstest.MapFS{
+		"bundle.zip": {Data: makeZip(t, archiveFile{"notes.txt", []byte("hay\nneedle\n")})},
+	}
+
+	matches, err := (&Driver{Archives: true}).MatchFS(context.Background(), fsys, "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal([]searchfiles.Match{{
+		Path:       "/bundle.zip!/notes.txt",
+		LineNumber: 2,
+		Offset:     4,
+		Column:     1,
+		Line:       "needle",
+		Submatches: []searchfiles.Submatch{{Start: 0, End: 6, Text: "needle"}},
+	}}, matches)
+}
+
+func TestDecompressFormats(t *testing.T) {
+	a := assert.New(t)
+
+	var zz bytes.Buffer
+	zw := zlib.NewWriter(&zz)
+	if _, err := zw.Write([]byte("a zlib needle\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"data.zz":     {Data: zz.Bytes()},
+		"app.log.gz":  {Data: makeGzip(t, []byte("a gzip needle\n"))},
+		"not-gzip.gz": {Data: []byte("a needle that was never compressed\n")},
+		"bundle.zip":  {Data: makeZip(t, archiveFile{"inner.log.gz", makeGzip(t, []byte("an archived needle\n"))})},
+	}
+
+	results, err := searchArchives(&Driver{Decompress: true}, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/app.log.gz", "/data.zz", "/not-gzip.gz"}, results)
+
+	results, err = searchArchives(&Driver{Decompress: true, Archives: true, ArchiveDepth: 1}, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/app.log.gz!/app.log", "/bundle.zip!/inner.log.gz", "/data.zz", "/not-gzip.gz"}, results)
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	a := assert.New(t)
+
+	gz := makeGzip(t, bytes.Repeat([]byte("a gzip needle\n"), 1000))
+
+	fsys := fstest.MapFS{
+		"a.log.gz":  {Data: gz[:len(gz)/2]},
+		"b.log.bz2": {Data: []byte("BZh9 a needle that isn't bzip2\n")},
+		"c.log.gz":  {Data: []byte{0x1f, 0x8b, 0xff}},
+		"d.txt":     {Data: []byte("a plain needle\n")},
+	}
+
+	var skipped []string
+	var mu sync.Mutex
+	d := &Driver{Decompress: true, Skipped: func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		skipped = append(skipped, name)
+	}}
+
+	results, err := searchArchives(d, fsys, "needle")
+	a.NoError(err)
+	a.Equal([]string{"/d.txt"}, results)
+	a.ElementsMatch([]string{"/a.log.gz", "/b.log.bz2", "/c.log.gz"}, skipped)
+}
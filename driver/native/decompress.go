@@ -0,0 +1,78 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// unreadableError is an error decoding a compressed file, which only stops
+// that one file from being searched.
+type unreadableError struct {
+	err error
+}
+
+func (e *unreadableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unreadableError) Unwrap() error {
+	return e.err
+}
+
+// decodeReader marks errors reading from rd, other than io.EOF, as
+// unreadableErrors.
+type decodeReader struct {
+	rd io.Reader
+}
+
+func (r *decodeReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	if err != nil && err != io.EOF {
+		err = &unreadableError{err: err}
+	}
+
+	return n, err
+}
+
+// decompress returns a reader for the decompressed contents of rd if name
+// has the extension of a compressed file. Files that don't start the way
+// their extension says they should are read as they are, as are files
+// without one of those extensions. Errors from decompressing are
+// unreadableErrors.
+func decompress(rd io.Reader, name string) (io.Reader, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext != ".gz" && ext != ".bz2" && ext != ".zz" {
+		return rd, nil
+	}
+
+	br := bufio.NewReader(rd)
+	magic, _ := br.Peek(3)
+
+	switch {
+	case ext == ".gz" && bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("native.decompress: could not read %q: %w", name, &unreadableError{err: err})
+		}
+		return &decodeReader{rd: zr}, nil
+	case ext == ".bz2" && bytes.HasPrefix(magic, []byte("BZh")):
+		return &decodeReader{rd: bzip2.NewReader(br)}, nil
+	case ext == ".zz" && len(magic) >= 2 && magic[0]&0x0f == 8 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0:
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("native.decompress: could not read %q: %w", name, &unreadableError{err: err})
+		}
+		return &decodeReader{rd: zr}, nil
+	default:
+		return br, nil
+	}
+}
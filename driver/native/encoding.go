@@ -0,0 +1,151 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// transcode returns a reader for the contents of rd as UTF-8, having decoded
+// them as encoding says. Like rg, a byte order mark at the start overrides the
+// encoding, and isn't itself searched. Anything that can't be decoded comes
+// out as utf8.RuneError.
+func transcode(rd io.Reader, encoding searchfiles.Encoding) io.Reader {
+	br := bufio.NewReader(rd)
+
+	switch {
+	case skipBOM(br, utf8BOM):
+		return br
+	case skipBOM(br, utf16LEBOM):
+		return newUTF16Reader(br, false)
+	case skipBOM(br, utf16BEBOM):
+		return newUTF16Reader(br, true)
+	}
+
+	switch encoding {
+	case searchfiles.EncodingLatin1:
+		return &runeReader{next: func() (rune, error) {
+			b, err := br.ReadByte()
+			return rune(b), err
+		}}
+	case searchfiles.EncodingUTF16LE:
+		return newUTF16Reader(br, false)
+	case searchfiles.EncodingUTF16BE:
+		return newUTF16Reader(br, true)
+	default:
+		return br
+	}
+}
+
+// skipBOM discards bom from the start of br, and reports whether it was
+// there.
+func skipBOM(br *bufio.Reader, bom []byte) bool {
+	b, _ := br.Peek(len(bom))
+	if !bytes.Equal(b, bom) {
+		return false
+	}
+
+	_, _ = br.Discard(len(bom))
+
+	return true
+}
+
+func newUTF16Reader(br *bufio.Reader, bigEndian bool) *runeReader {
+	unit := func() (uint16, error) {
+		var b [2]byte
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			// A byte left over at the end is as much of a character as
+			// can be made out.
+			if err == io.ErrUnexpectedEOF {
+				return utf8.RuneError, nil
+			}
+			return 0, err
+		}
+
+		if bigEndian {
+			return uint16(b[0])<<8 | uint16(b[1]), nil
+		}
+
+		return uint16(b[1])<<8 | uint16(b[0]), nil
+	}
+
+	return &runeReader{next: func() (rune, error) {
+		u, err := unit()
+		if err != nil {
+			return 0, err
+		}
+
+		if !utf16.IsSurrogate(rune(u)) {
+			return rune(u), nil
+		}
+
+		// Only look at the next unit without taking it, so that it can start
+		// a character of its own if it doesn't finish this one.
+		b, _ := br.Peek(2)
+		if len(b) == 2 {
+			v := uint16(b[1])<<8 | uint16(b[0])
+			if bigEndian {
+				v = uint16(b[0])<<8 | uint16(b[1])
+			}
+
+			if r := utf16.DecodeRune(rune(u), rune(v)); r != utf8.RuneError {
+				_, _ = br.Discard(2)
+				return r, nil
+			}
+		}
+
+		return utf8.RuneError, nil
+	}}
+}
+
+// runeReader encodes the characters returned by next as UTF-8.
+type runeReader struct {
+	next    func() (rune, error)
+	pending []byte
+	err     error
+}
+
+func (r *runeReader) Read(p []byte) (int, error) {
+	var n int
+
+	for n < len(p) {
+		if len(r.pending) > 0 {
+			c := copy(p[n:], r.pending)
+			r.pending = r.pending[c:]
+			n += c
+			continue
+		}
+
+		if r.err != nil {
+			break
+		}
+
+		c, err := r.next()
+		if err != nil {
+			r.err = err
+			continue
+		}
+
+		if len(p)-n >= utf8.UTFMax {
+			n += utf8.EncodeRune(p[n:], c)
+		} else {
+			r.pending = utf8.AppendRune(nil, c)
+		}
+	}
+
+	if n > 0 {
+		return n, nil
+	}
+
+	return 0, r.err
+}
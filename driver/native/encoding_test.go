@@ -0,0 +1,43 @@
+package native
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+func TestTranscode(t *testing.T) {
+	a := assert.New(t)
+
+	for _, tc := range []struct {
+		name     string
+		encoding searchfiles.Encoding
+		input    []byte
+		output   string
+	}{
+		{"AutoPlain", searchfiles.EncodingAuto, []byte("caf\xc3\xa9"), "café"},
+		{"AutoUTF8BOM", searchfiles.EncodingAuto, []byte("\xef\xbb\xbfcafé"), "café"},
+		{"AutoUTF16LEBOM", searchfiles.EncodingAuto, []byte("\xff\xfeh\x00i\x00"), "hi"},
+		{"AutoUTF16BEBOM", searchfiles.EncodingAuto, []byte("\xfe\xff\x00h\x00i"), "hi"},
+		{"UTF8", searchfiles.EncodingUTF8, []byte("caf\xe9"), "caf\xe9"},
+		{"Latin1", searchfiles.EncodingLatin1, []byte("caf\xe9 \xff"), "café ÿ"},
+		{"UTF16LE", searchfiles.EncodingUTF16LE, []byte("h\x00i\x00"), "hi"},
+		{"UTF16BE", searchfiles.EncodingUTF16BE, []byte("\x00h\x00i"), "hi"},
+		{"BOMOverrides", searchfiles.EncodingUTF16LE, []byte("\xfe\xff\x00h\x00i"), "hi"},
+		{"SurrogatePair", searchfiles.EncodingUTF16LE, []byte("\x3d\xd8\x00\xdeA\x00"), "😀A"},
+		{"LoneSurrogate", searchfiles.EncodingUTF16LE, []byte("\x3d\xd8A\x00"), "�A"},
+		{"OddByte", searchfiles.EncodingUTF16BE, []byte("\x00h\x00"), "h�"},
+	} {
+		// Reading a byte at a time makes sure characters split across reads
+		// come out whole.
+		b, err := io.ReadAll(iotest.OneByteReader(transcode(bytes.NewReader(tc.input), tc.encoding)))
+		if a.NoError(err, tc.name) {
+			a.Equal(tc.output, string(b), tc.name)
+		}
+	}
+}
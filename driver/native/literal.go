@@ -0,0 +1,51 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// literalBufferSize is how much of a file matchLiteral looks at in one go.
+const literalBufferSize = 256 * 1024
+
+// matchLiteral reports whether needle appears anywhere in rd. It reads rd in
+// large blocks and searches each one with bytes.Index. The last
+// len(needle)-1 bytes of every block are carried over to the next, so a
+// match that straddles two reads is still found.
+func matchLiteral(ctx context.Context, needle []byte, rd io.Reader) (bool, error) {
+	size := literalBufferSize
+	if size < 2*len(needle) {
+		size = 2 * len(needle)
+	}
+
+	buf := make([]byte, size)
+	keep := len(needle) - 1
+
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, fmt.Errorf("native.matchLiteral: %w", err)
+		}
+
+		m, err := rd.Read(buf[n:])
+		n += m
+
+		if bytes.Index(buf[:n], needle) != -1 {
+			return true, nil
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("native.matchLiteral: %w", err)
+		}
+
+		// Anything before the tail has been searched and can't be part of a
+		// match, so it's dropped to make room for the next read.
+		if n > keep && n > len(buf)/2 {
+			n = copy(buf, buf[n-keep:n])
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package native
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchLiteral(t *testing.T) {
+	a := assert.New(t)
+
+	padding := strings.Repeat("x", literalBufferSize)
+
+	for _, tc := range []struct {
+		name    string
+		needle  string
+		input   string
+		matched bool
+	}{
+		{"Empty", "needle", "", false},
+		{"EmptyNeedle", "", "", true},
+		{"Start", "needle", "needle" + padding, true},
+		{"End", "needle", padding + padding + "needle", true},
+		{"Boundary", "needle", padding[:literalBufferSize-3] + "needle" + padding, true},
+		{"SecondBoundary", "needle", padding + padding[:literalBufferSize/2-2] + "needle", true},
+		{"Partial", "needle", padding[:literalBufferSize-3] + "need" + padding + "le", false},
+		{"LongNeedle", padding + "y", padding + padding + "y", true},
+	} {
+		for _, rd := range []struct {
+			name string
+			fn   func(rd io.Reader) io.Reader
+		}{
+			{"Whole", func(rd io.Reader) io.Reader { return rd }},
+			{"Half", iotest.HalfReader},
+			{"DataErr", iotest.DataErrReader},
+		} {
+			matched, err := matchLiteral(context.Background(), []byte(tc.needle), rd.fn(strings.NewReader(tc.input)))
+			if a.NoError(err, "%s/%s", tc.name, rd.name) {
+				a.Equal(tc.matched, matched, "%s/%s", tc.name, rd.name)
+			}
+		}
+	}
+}
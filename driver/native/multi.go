@@ -0,0 +1,171 @@
+package native
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/multi"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
+	"fknsrs.biz/p/searchfiles/internal/sniff"
+)
+
+func (d *Driver) StreamMulti(ctx context.Context, directory string, queries []string, mode searchfiles.MultiMode, options searchfiles.SearchOptions, fn searchfiles.MultiFunc) error {
+	if options.Invert {
+		return fmt.Errorf("native.Driver.StreamMulti: inverted matching: %w", searchfiles.ErrUnimplemented)
+	}
+	if options.Multiline {
+		return fmt.Errorf("native.Driver.StreamMulti: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	set, err := multi.Compile(queries, options)
+	if err != nil {
+		return fmt.Errorf("native.Driver.StreamMulti: could not compile queries: %w", err)
+	}
+
+	if len(queries) == 0 {
+		return nil
+	}
+
+	w, err := d.newWalker(os.DirFS(directory), options)
+	if err != nil {
+		return fmt.Errorf("native.Driver.StreamMulti: %w", err)
+	}
+
+	m := &multiMatcher{set: set, binary: options.Binary}
+
+	// If every query is a plain string, they can all be found at once
+	// without splitting the file into lines.
+	options = pattern.ResolveCase(queries, options)
+
+	needles := make([][]byte, len(queries))
+	for i, query := range queries {
+		literal, ok := pattern.Literal(query, options)
+		if !ok {
+			needles = nil
+			break
+		}
+		needles[i] = []byte(literal)
+	}
+	if needles != nil {
+		m.ac = newAhoCorasick(needles)
+	}
+
+	if err := searchFiles(ctx, d.workers(), w, func(ctx context.Context, rd io.Reader, name string) ([]bool, error) {
+		return m.matchFile(ctx, rd)
+	}, func(name string, found []bool) error {
+		if r, ok := multi.Result(name, found, mode); ok {
+			return fn(r)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("native.Driver.StreamMulti: %w", err)
+	}
+
+	return nil
+}
+
+// multiMatcher works out which of a set of patterns appear in a file.
+type multiMatcher struct {
+	set *multi.Set
+	// ac is set if all the patterns are plain strings.
+	ac     *ahoCorasick
+	binary bool
+}
+
+func (m *multiMatcher) matchFile(ctx context.Context, rd io.Reader) ([]bool, error) {
+	br := bufio.NewReaderSize(&contextReader{ctx: ctx, rd: rd}, sniff.BlockSize)
+
+	if !m.binary {
+		if binary, err := isBinary(br); err != nil {
+			return nil, fmt.Errorf("native.multiMatcher.matchFile: %w", err)
+		} else if binary {
+			return nil, nil
+		}
+	}
+
+	var (
+		found []bool
+		err   error
+	)
+	if m.ac != nil {
+		found, err = m.scanLiterals(ctx, br)
+	} else {
+		found, err = m.scanLines(ctx, br)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("native.multiMatcher.matchFile: %w", err)
+	}
+
+	return found, nil
+}
+
+// scanLiterals runs the whole of rd through the Aho-Corasick automaton,
+// stopping early if every pattern has been found.
+func (m *multiMatcher) scanLiterals(ctx context.Context, rd io.Reader) ([]bool, error) {
+	found := make([]bool, m.set.Len())
+	remaining := len(found)
+
+	buf := make([]byte, literalBufferSize)
+
+	var state int32
+	for started := false; remaining > 0; {
+		n, err := rd.Read(buf)
+		if n > 0 && !started {
+			// Empty patterns match every line, so they're found in any
+			// file that has at least one.
+			for _, i := range m.ac.empty {
+				found[i] = true
+				remaining--
+			}
+			started = true
+		}
+
+		var c int
+		state, c = m.ac.scan(state, buf[:n], found)
+		remaining -= c
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, fmt.Errorf("native.multiMatcher.scanLiterals: %w", ctxErr)
+			}
+			return nil, fmt.Errorf("native.multiMatcher.scanLiterals: %w", err)
+		}
+	}
+
+	return found, nil
+}
+
+// scanLines matches every pattern against each line of rd, stopping early
+// if every pattern has been found.
+func (m *multiMatcher) scanLines(ctx context.Context, rd io.Reader) ([]bool, error) {
+	found := make([]bool, m.set.Len())
+	remaining := len(found)
+
+	br := bufio.NewReader(rd)
+
+	for remaining > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("native.multiMatcher.scanLines: %w", err)
+		}
+
+		line, err := br.ReadString('\n')
+		if line == "" && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("native.multiMatcher.scanLines: %w", err)
+		}
+
+		remaining -= m.set.Match(strings.TrimSuffix(line, "\n"), found)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,155 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/matchline"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
+)
+
+// spansLines reports whether matches for query have to be found in whole
+// files rather than line by line. As with rg, that's only when Multiline is
+// set and the query can match a line break, so matches of other queries on
+// neighbouring lines aren't grouped together.
+func spansLines(query string, options searchfiles.SearchOptions) bool {
+	return options.Multiline && pattern.MatchesNewline(query, options)
+}
+
+// matchBlocks finds the matches of re in the whole of rd, so they can span
+// lines. Each match is reported along with the whole lines it covers, and
+// matches covering the same or neighbouring lines are reported together, as
+// rg does with --multiline.
+func matchBlocks(ctx context.Context, re *regexp.Regexp, path string, rd io.Reader) ([]searchfiles.Match, error) {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, fmt.Errorf("native.matchBlocks: %w", err)
+	}
+
+	var (
+		matches    []searchfiles.Match
+		start, end int
+		lineNumber = 1
+		counted    int
+	)
+
+	flush := func(submatches [][]int) {
+		lineNumber += bytes.Count(b[counted:start], []byte("\n"))
+		counted = start
+
+		m := searchfiles.Match{
+			Path:       path,
+			LineNumber: lineNumber,
+			Offset:     int64(start),
+			Line:       strings.TrimSuffix(string(b[start:end]), "\n"),
+		}
+		for _, loc := range submatches {
+			m.Submatches = append(m.Submatches, searchfiles.Submatch{
+				Start: loc[0] - start,
+				End:   loc[1] - start,
+				Text:  string(b[loc[0]:loc[1]]),
+			})
+		}
+		m.Column = m.Submatches[0].Start + 1
+
+		matches = append(matches, m)
+	}
+
+	var pending [][]int
+	for _, loc := range re.FindAllIndex(b, -1) {
+		// The last line a match covers is the one its last byte is on, which
+		// is the line break itself if it ends with one.
+		last := loc[0]
+		if loc[1] > loc[0] {
+			last = loc[1] - 1
+		}
+
+		lineStart := bytes.LastIndexByte(b[:loc[0]], '\n') + 1
+		lineEnd := len(b)
+		if i := bytes.IndexByte(b[last:], '\n'); i != -1 {
+			lineEnd = last + i + 1
+		}
+
+		if len(pending) > 0 && lineStart <= end {
+			pending = append(pending, loc)
+			if lineEnd > end {
+				end = lineEnd
+			}
+			continue
+		}
+
+		if len(pending) > 0 {
+			flush(pending)
+		}
+
+		pending, start, end = [][]int{loc}, lineStart, lineEnd
+	}
+	if len(pending) > 0 {
+		flush(pending)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("native.matchBlocks: %w", err)
+	}
+
+	return matches, nil
+}
+
+// contextBlocks is like matchBlocks, but passes every line to b, which keeps
+// those that are near enough to a match.
+func contextBlocks(ctx context.Context, re *regexp.Regexp, path string, rd io.Reader, b *matchline.ContextBuilder) error {
+	data, err := io.ReadAll(rd)
+	if err != nil {
+		return fmt.Errorf("native.contextBlocks: %w", err)
+	}
+
+	matches, err := matchBlocks(ctx, re, path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("native.contextBlocks: %w", err)
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+
+		if len(matches) > 0 && matches[0].LineNumber == lineNumber {
+			b.Match(matches[0])
+			i += strings.Count(matches[0].Line, "\n")
+			matches = matches[1:]
+			continue
+		}
+
+		b.Context(path, lineNumber, strings.TrimSuffix(lines[i], "\n"))
+	}
+
+	return nil
+}
+
+// countBlocks counts the lines covered by matches of re in rd, or with
+// occurrences, the matches themselves.
+func countBlocks(ctx context.Context, re *regexp.Regexp, occurrences bool, rd io.Reader) (int, error) {
+	matches, err := matchBlocks(ctx, re, "", rd)
+	if err != nil {
+		return 0, fmt.Errorf("native.countBlocks: %w", err)
+	}
+
+	n := 0
+	for _, m := range matches {
+		if occurrences {
+			n += len(m.Submatches)
+		} else {
+			n += strings.Count(m.Line, "\n") + 1
+		}
+	}
+
+	return n, nil
+}
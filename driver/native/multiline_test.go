@@ -0,0 +1,54 @@
+package native
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+func TestMatchBlocks(t *testing.T) {
+	a := assert.New(t)
+
+	for _, tc := range []struct {
+		name    string
+		expr    string
+		input   string
+		matches []searchfiles.Match
+	}{
+		{
+			"Separate",
+			`a\nb|d`,
+			"a\nb\nc\nd",
+			[]searchfiles.Match{
+				{LineNumber: 1, Offset: 0, Column: 1, Line: "a\nb", Submatches: []searchfiles.Submatch{{Start: 0, End: 3, Text: "a\nb"}}},
+				{LineNumber: 4, Offset: 6, Column: 1, Line: "d", Submatches: []searchfiles.Submatch{{Start: 0, End: 1, Text: "d"}}},
+			},
+		},
+		{
+			"Neighbouring",
+			`a\nb|c`,
+			"a\nb\nc\n",
+			[]searchfiles.Match{
+				{LineNumber: 1, Offset: 0, Column: 1, Line: "a\nb\nc", Submatches: []searchfiles.Submatch{{Start: 0, End: 3, Text: "a\nb"}, {Start: 4, End: 5, Text: "c"}}},
+			},
+		},
+		{
+			"EndsWithLineBreak",
+			`b\n`,
+			"a\nb\nc\n",
+			[]searchfiles.Match{
+				{LineNumber: 2, Offset: 2, Column: 1, Line: "b", Submatches: []searchfiles.Submatch{{Start: 0, End: 2, Text: "b\n"}}},
+			},
+		},
+	} {
+		matches, err := matchBlocks(context.Background(), regexp.MustCompile(tc.expr), "", strings.NewReader(tc.input))
+		if a.NoError(err, tc.name) {
+			a.Equal(tc.matches, matches, tc.name)
+		}
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 
 	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/matchline"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
+	"fknsrs.biz/p/searchfiles/internal/sniff"
 )
 
 var (
@@ -22,14 +26,68 @@ func init() {
 	searchfiles.Register("native", Default)
 }
 
-type Driver struct{}
+type Driver struct {
+	// Workers is the number of files searched at once. If it's zero,
+	// runtime.GOMAXPROCS(0) is used.
+	Workers int
+	// Archives makes searches look inside zip, tar, tar.gz and gzip files,
+	// going by their extensions, and report what's found in them as
+	// "/bundle.tar.gz!/inner/path.txt". Include, Exclude and Types apply to
+	// the archive itself rather than the files in it.
+	Archives bool
+	// ArchiveDepth is how many levels of archives inside archives are looked
+	// into. If it's zero, DefaultArchiveDepth is used.
+	ArchiveDepth int
+	// ArchiveSize is the most that can be read out of one archive, including
+	// any archives inside it, before it's skipped with ErrArchiveTooLarge.
+	// If it's zero, DefaultArchiveSize is used.
+	ArchiveSize int64
+	// Decompress makes searches look at the decompressed contents of gzip
+	// (.gz), bzip2 (.bz2) and zlib (.zz) files, which are still reported
+	// under their own names, like rg --search-zip.
+	Decompress bool
+	// Skipped is called with the name of each compressed file or archive
+	// that's left out of a search because it couldn't be read, or was too
+	// large, and why. Nothing found in a skipped archive is reported. It can
+	// be called from several goroutines at once.
+	Skipped func(name string, err error)
+}
+
+func (d *Driver) workers() int {
+	if d.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return d.Workers
+}
+
+func (d *Driver) archiveLimits() *archiveLimits {
+	if !d.Archives {
+		return nil
+	}
+
+	l := &archiveLimits{depth: d.ArchiveDepth, size: d.ArchiveSize}
+	if l.depth <= 0 {
+		l.depth = DefaultArchiveDepth
+	}
+	if l.size <= 0 {
+		l.size = DefaultArchiveSize
+	}
+
+	return l
+}
 
 func (d *Driver) SelfTest(ctx context.Context) error {
 	return nil
 }
 
+func (d *Driver) Capabilities(ctx context.Context) (searchfiles.Capabilities, error) {
+	return searchfiles.CapabilityRegexp | searchfiles.CapabilityInvert | searchfiles.CapabilityMultiline |
+		searchfiles.CapabilityBinary | searchfiles.CapabilityEncoding | searchfiles.CapabilityVCSIgnore |
+		searchfiles.CapabilityDecompress | searchfiles.CapabilityArchives, nil
+}
+
 func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
-	a, err := d.search(ctx, directory, regexp.QuoteMeta(query))
+	a, err := d.search(ctx, directory, query, searchfiles.SearchOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("native.Driver.SearchLiteral: %w", err)
 	}
@@ -38,81 +96,503 @@ func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]
 }
 
 func (d *Driver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
-	a, err := d.search(ctx, directory, query)
+	a, err := d.search(ctx, directory, query, searchfiles.SearchOptions{Regexp: true})
 	if err != nil {
-		return nil, fmt.Errorf("native.Driver.SearchLiteral: %w", err)
+		return nil, fmt.Errorf("native.Driver.SearchRegexp: %w", err)
+	}
+
+	return a, nil
+}
+
+func (d *Driver) StreamLiteral(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{}, fn); err != nil {
+		return fmt.Errorf("native.Driver.StreamLiteral: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamRegexp(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true}, fn); err != nil {
+		return fmt.Errorf("native.Driver.StreamRegexp: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions, fn searchfiles.StreamFunc) error {
+	if err := d.StreamFS(ctx, os.DirFS(directory), query, options, fn); err != nil {
+		return fmt.Errorf("native.Driver.StreamWithOptions: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamFS(ctx context.Context, fsys fs.FS, query string, options searchfiles.SearchOptions, fn searchfiles.StreamFunc) error {
+	if options.Invert && options.Multiline {
+		return fmt.Errorf("native.Driver.StreamFS: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	re, err := pattern.Compile(query, options)
+	if err != nil {
+		return fmt.Errorf("native.Driver.StreamFS: could not compile query: %w", err)
+	}
+
+	w, err := d.newWalker(fsys, options)
+	if err != nil {
+		return fmt.Errorf("native.Driver.StreamFS: %w", err)
+	}
+
+	m := &fileMatcher{
+		re:      re,
+		invert:  options.Invert,
+		binary:  options.Binary,
+		without: options.FilesWithoutMatch,
+		// Inverted matches have to look at every line, and queries that can
+		// match a line break mustn't match across lines unless they're allowed
+		// to, so in both cases the file has to be searched line by line rather
+		// than as a whole.
+		byLine: options.Invert || (!options.Multiline && pattern.MatchesNewline(query, options)),
+	}
+	if literal, ok := pattern.Literal(query, options); ok {
+		m.literal, m.needle = true, []byte(literal)
+	}
+
+	if err := searchFiles(ctx, d.workers(), w, func(ctx context.Context, rd io.Reader, name string) (bool, error) {
+		return m.matchFile(ctx, rd)
+	}, func(name string, matched bool) error {
+		if !matched {
+			return nil
+		}
+		return fn(name)
+	}); err != nil {
+		return fmt.Errorf("native.Driver.StreamFS: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) MatchLiteral(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	a, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchLiteral: %w", err)
 	}
 
 	return a, nil
 }
 
-func (d *Driver) search(ctx context.Context, directory, query string) ([]string, error) {
-	re, err := regexp.Compile(query)
+func (d *Driver) MatchRegexp(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	a, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true})
 	if err != nil {
-		return nil, fmt.Errorf("native.Driver.search: could not compile query: %w", err)
+		return nil, fmt.Errorf("native.Driver.MatchRegexp: %w", err)
 	}
 
-	collector := &matchCollector{ctx: ctx, directory: directory, regexp: re}
+	return a, nil
+}
 
-	if err := filepath.Walk(directory, collector.walk); err != nil {
-		return nil, fmt.Errorf("native.Driver.search: could not walk directory: %w", err)
+func (d *Driver) MatchWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]searchfiles.Match, error) {
+	a, err := d.MatchFS(ctx, os.DirFS(directory), query, options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchWithOptions: %w", err)
 	}
 
-	return []string(collector.files), nil
+	return a, nil
 }
 
-type matchCollector struct {
-	ctx       context.Context
-	directory string
-	regexp    *regexp.Regexp
-	files     []string
+func (d *Driver) MatchFS(ctx context.Context, fsys fs.FS, query string, options searchfiles.SearchOptions) ([]searchfiles.Match, error) {
+	if options.Invert && options.Multiline {
+		return nil, fmt.Errorf("native.Driver.MatchFS: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	re, err := pattern.Compile(query, options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchFS: could not compile query: %w", err)
+	}
+
+	w, err := d.newWalker(fsys, options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchFS: %w", err)
+	}
+
+	multiline := spansLines(query, options)
+
+	var matches []searchfiles.Match
+
+	if err := searchFiles(ctx, d.workers(), w, func(ctx context.Context, rd io.Reader, name string) ([]searchfiles.Match, error) {
+		return matchFileLines(ctx, re, options.Invert, multiline, options.Binary, rd, name)
+	}, func(name string, a []searchfiles.Match) error {
+		matches = append(matches, a...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchFS: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (d *Driver) MatchWithContext(ctx context.Context, directory, query string, before, after int, options searchfiles.SearchOptions) ([]searchfiles.ContextMatch, error) {
+	if options.Invert && options.Multiline {
+		return nil, fmt.Errorf("native.Driver.MatchWithContext: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	re, err := pattern.Compile(query, options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchWithContext: could not compile query: %w", err)
+	}
+
+	w, err := d.newWalker(os.DirFS(directory), options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchWithContext: %w", err)
+	}
+
+	multiline := spansLines(query, options)
+
+	var matches []searchfiles.ContextMatch
+
+	if err := searchFiles(ctx, d.workers(), w, func(ctx context.Context, rd io.Reader, name string) ([]searchfiles.ContextMatch, error) {
+		b := matchline.NewContextBuilder(before, after)
+		if err := contextFileLines(ctx, re, options.Invert, multiline, options.Binary, rd, name, b); err != nil {
+			return nil, err
+		}
+		return b.Matches(), nil
+	}, func(name string, a []searchfiles.ContextMatch) error {
+		matches = append(matches, a...)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("native.Driver.MatchWithContext: %w", err)
+	}
+
+	return matches, nil
 }
 
-func (c *matchCollector) walk(path string, info fs.FileInfo, pathErr error) error {
-	if pathErr != nil {
-		return pathErr
+func (d *Driver) CountWithOptions(ctx context.Context, directory, query string, mode searchfiles.CountMode, options searchfiles.SearchOptions) (searchfiles.Counts, error) {
+	if options.Invert && options.Multiline {
+		return nil, fmt.Errorf("native.Driver.CountWithOptions: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	re, err := pattern.Compile(query, options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.CountWithOptions: could not compile query: %w", err)
 	}
 
-	if !info.Mode().IsRegular() {
+	w, err := d.newWalker(os.DirFS(directory), options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.CountWithOptions: %w", err)
+	}
+
+	multiline := spansLines(query, options)
+
+	occurrences := mode == searchfiles.CountOccurrences && !options.Invert
+
+	counts := searchfiles.Counts{}
+
+	if err := searchFiles(ctx, d.workers(), w, func(ctx context.Context, rd io.Reader, name string) (int, error) {
+		return countFile(ctx, re, options.Invert, multiline, occurrences, options.Binary, rd)
+	}, func(name string, n int) error {
+		if n > 0 {
+			counts[name] = n
+		}
 		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("native.Driver.CountWithOptions: %w", err)
 	}
 
-	if err := c.ctx.Err(); err != nil {
-		return fmt.Errorf("native.matchCollector.walk: %w", err)
+	return counts, nil
+}
+
+func (d *Driver) search(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]string, error) {
+	var files []string
+
+	if err := d.StreamWithOptions(ctx, directory, query, options, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("native.Driver.search: %w", err)
+	}
+
+	return files, nil
+}
+
+// isBinary reports whether the first block of br looks like binary data,
+// without consuming it.
+func isBinary(br *bufio.Reader) (bool, error) {
+	b, err := br.Peek(sniff.BlockSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return false, fmt.Errorf("native.isBinary: %w", err)
 	}
 
-	fd, err := os.Open(path)
+	return sniff.IsBinary(b), nil
+}
+
+// contextReader stops reading from rd once ctx is done, so a search that's
+// been cancelled doesn't carry on through the rest of a large file.
+type contextReader struct {
+	ctx context.Context
+	rd  io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.rd.Read(p)
+}
+
+// fileMatcher decides whether a file should be listed in the results, which
+// doesn't need to know where the query matched.
+type fileMatcher struct {
+	re *regexp.Regexp
+	// literal is set if the query can be found by looking for needle
+	// directly, which is a lot faster than going through re.
+	literal bool
+	needle  []byte
+	invert  bool
+	byLine  bool
+	binary  bool
+	// without lists the files that don't match instead.
+	without bool
+}
+
+// matchFile reports whether the file read from rd should be listed. Binary
+// files are never listed unless binary is set, whether or not without is.
+func (m *fileMatcher) matchFile(ctx context.Context, rd io.Reader) (bool, error) {
+	br := bufio.NewReaderSize(&contextReader{ctx: ctx, rd: rd}, sniff.BlockSize)
+
+	if !m.binary {
+		if binary, err := isBinary(br); err != nil {
+			return false, fmt.Errorf("native.fileMatcher.matchFile: %w", err)
+		} else if binary {
+			return false, nil
+		}
+	}
+
+	var (
+		matched bool
+		err     error
+	)
+	switch {
+	case m.byLine:
+		matched, err = matchAnyLine(ctx, m.re, m.invert, br)
+	case m.literal:
+		matched, err = matchLiteral(ctx, m.needle, br)
+	default:
+		matched, err = matchReader(ctx, m.re, br)
+	}
 	if err != nil {
-		return fmt.Errorf("native.matchCollector.walk: could not open file %q: %w", path, err)
+		return false, fmt.Errorf("native.fileMatcher.matchFile: %w", err)
+	}
+
+	return matched != m.without, nil
+}
+
+func matchFileLines(ctx context.Context, re *regexp.Regexp, invert, multiline, binary bool, rd io.Reader, name string) ([]searchfiles.Match, error) {
+	br := bufio.NewReaderSize(&contextReader{ctx: ctx, rd: rd}, sniff.BlockSize)
+
+	if !binary {
+		if binary, err := isBinary(br); err != nil {
+			return nil, fmt.Errorf("native.matchFileLines: %w", err)
+		} else if binary {
+			return nil, nil
+		}
 	}
-	defer fd.Close()
 
-	matched, err := matchReader(c.ctx, c.regexp, fd)
+	var (
+		matches []searchfiles.Match
+		err     error
+	)
+	if multiline {
+		matches, err = matchBlocks(ctx, re, name, br)
+	} else {
+		matches, err = matchLines(ctx, re, invert, name, br)
+	}
 	if err != nil {
-		return fmt.Errorf("native.matchCollector.walk: could not search file %q: %w", path, err)
+		return nil, fmt.Errorf("native.matchFileLines: %w", err)
 	}
 
-	if matched {
-		c.files = append(c.files, strings.TrimPrefix(path, c.directory))
+	return matches, nil
+}
+
+func contextFileLines(ctx context.Context, re *regexp.Regexp, invert, multiline, binary bool, rd io.Reader, name string, b *matchline.ContextBuilder) error {
+	br := bufio.NewReaderSize(&contextReader{ctx: ctx, rd: rd}, sniff.BlockSize)
+
+	if !binary {
+		if binary, err := isBinary(br); err != nil {
+			return fmt.Errorf("native.contextFileLines: %w", err)
+		} else if binary {
+			return nil
+		}
 	}
 
-	if err := fd.Close(); err != nil {
-		return fmt.Errorf("native.matchCollector.walk: could not close file %q: %w", path, err)
+	var err error
+	if multiline {
+		err = contextBlocks(ctx, re, name, br, b)
+	} else {
+		err = contextLines(ctx, re, invert, name, br, b)
+	}
+	if err != nil {
+		return fmt.Errorf("native.contextFileLines: %w", err)
 	}
 
 	return nil
 }
 
-func matchReader(ctx context.Context, re *regexp.Regexp, fd *os.File) (bool, error) {
-	ch := make(chan bool, 1)
-	go func() {
-		ch <- re.MatchReader(bufio.NewReader(fd))
-	}()
+// contextLines is like matchLines, but passes every line to b, which keeps
+// those that are near enough to a match.
+func contextLines(ctx context.Context, re *regexp.Regexp, invert bool, path string, rd io.Reader, b *matchline.ContextBuilder) error {
+	br := bufio.NewReader(rd)
+
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("native.contextLines: %w", err)
+		}
+
+		line, err := br.ReadString('\n')
+		if line == "" && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("native.contextLines: %w", err)
+		}
+
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case re.MatchString(text) == invert:
+			b.Context(path, lineNumber, text)
+		case invert:
+			b.Match(matchline.New(path, lineNumber, offset, text, nil))
+		default:
+			b.Match(matchline.New(path, lineNumber, offset, text, re))
+		}
+
+		offset += int64(len(line))
+	}
+}
+
+func countFile(ctx context.Context, re *regexp.Regexp, invert, multiline, occurrences, binary bool, rd io.Reader) (int, error) {
+	br := bufio.NewReaderSize(&contextReader{ctx: ctx, rd: rd}, sniff.BlockSize)
+
+	if !binary {
+		if binary, err := isBinary(br); err != nil {
+			return 0, fmt.Errorf("native.countFile: %w", err)
+		} else if binary {
+			return 0, nil
+		}
+	}
+
+	var (
+		n   int
+		err error
+	)
+	if multiline {
+		n, err = countBlocks(ctx, re, occurrences, br)
+	} else {
+		n, err = countLines(ctx, re, invert, occurrences, br)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("native.countFile: %w", err)
+	}
+
+	return n, nil
+}
+
+// countLines counts the lines in rd that match re, or with invert, those
+// that don't. With occurrences, each line counts once for every match in it
+// instead.
+func countLines(ctx context.Context, re *regexp.Regexp, invert, occurrences bool, rd io.Reader) (int, error) {
+	br := bufio.NewReader(rd)
+
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, fmt.Errorf("native.countLines: %w", err)
+		}
+
+		line, err := br.ReadString('\n')
+		if line == "" && err == io.EOF {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("native.countLines: %w", err)
+		}
 
-	select {
-	case <-ctx.Done():
-		return false, fmt.Errorf("native.matchReader: %w", ctx.Err())
-	case r := <-ch:
-		return r, nil
+		text := strings.TrimSuffix(line, "\n")
+		if occurrences {
+			n += len(re.FindAllStringIndex(text, -1))
+		} else if re.MatchString(text) != invert {
+			n++
+		}
 	}
 }
+
+// matchReader reports whether re matches anything in br. The regexp package
+// treats a read error as the end of the input, so if br was cut short by
+// cancellation, that's reported here instead of a non-match.
+func matchReader(ctx context.Context, re *regexp.Regexp, br *bufio.Reader) (bool, error) {
+	matched := re.MatchReader(br)
+
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("native.matchReader: %w", err)
+	}
+
+	return matched, nil
+}
+
+// matchAnyLine reports whether any line in rd matches re, or with invert,
+// whether any line doesn't match it.
+func matchAnyLine(ctx context.Context, re *regexp.Regexp, invert bool, rd io.Reader) (bool, error) {
+	br := bufio.NewReader(rd)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, fmt.Errorf("native.matchAnyLine: %w", err)
+		}
+
+		line, err := br.ReadString('\n')
+		if line == "" && err == io.EOF {
+			return false, nil
+		}
+		if err != nil && err != io.EOF {
+			return false, fmt.Errorf("native.matchAnyLine: %w", err)
+		}
+
+		if re.MatchString(strings.TrimSuffix(line, "\n")) != invert {
+			return true, nil
+		}
+	}
+}
+
+func matchLines(ctx context.Context, re *regexp.Regexp, invert bool, path string, rd io.Reader) ([]searchfiles.Match, error) {
+	var matches []searchfiles.Match
+
+	br := bufio.NewReader(rd)
+
+	var offset int64
+	for lineNumber := 1; ; lineNumber++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("native.matchLines: %w", err)
+		}
+
+		line, err := br.ReadString('\n')
+		if line == "" && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("native.matchLines: %w", err)
+		}
+
+		text := strings.TrimSuffix(line, "\n")
+		if re.MatchString(text) != invert {
+			if invert {
+				matches = append(matches, matchline.New(path, lineNumber, offset, text, nil))
+			} else {
+				matches = append(matches, matchline.New(path, lineNumber, offset, text, re))
+			}
+		}
+
+		offset += int64(len(line))
+	}
+
+	return matches, nil
+}
@@ -10,7 +10,18 @@ func TestShared(t *testing.T) {
 	tests.Test_All(Default, t)
 }
 
+func TestDecompress(t *testing.T) {
+	tests.Test_Decompress(&Driver{Decompress: true}, t)
+}
+
+func TestSharedFS(t *testing.T) {
+	tests.Test_AllFS(Default, t)
+}
+
 func BenchmarkShared(b *testing.B) {
 	tests.Benchmark_All(Default, b)
 }
 
+func BenchmarkSingleWorker(b *testing.B) {
+	tests.Benchmark_LargeCorpus(&Driver{Workers: 1}, b)
+}
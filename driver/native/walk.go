@@ -0,0 +1,276 @@
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/glob"
+	"fknsrs.biz/p/searchfiles/internal/ignore"
+)
+
+// walker finds the files to search in a file system, skipping the ones that
+// are filtered out or ignored.
+type walker struct {
+	fsys    fs.FS
+	filter  *glob.Filter
+	ignores *ignore.Matcher
+	// archives is set if archives are to be searched inside.
+	archives *archiveLimits
+	// decompress is set if compressed files are to be searched as if they
+	// weren't.
+	decompress bool
+	// encoding is what files are transcoded from before they're searched.
+	encoding searchfiles.Encoding
+	// skipped is called with files that are left out because they couldn't
+	// be read.
+	skipped func(name string, err error)
+}
+
+func (d *Driver) newWalker(fsys fs.FS, options searchfiles.SearchOptions) (*walker, error) {
+	filter, err := glob.NewFilter(options)
+	if err != nil {
+		return nil, fmt.Errorf("native.Driver.newWalker: %w", err)
+	}
+
+	return &walker{
+		fsys:       fsys,
+		filter:     filter,
+		ignores:    ignore.NewFS(fsys, options.Ignore),
+		archives:   d.archiveLimits(),
+		decompress: d.Decompress,
+		encoding:   options.Encoding,
+		skipped:    d.Skipped,
+	}, nil
+}
+
+// walk calls fn for each file to be searched, in lexical order. The path
+// passed to fn is the one to open it with in w.fsys, and the name is in the
+// same form as search results.
+func (w *walker) walk(ctx context.Context, fn func(path, name string) error) error {
+	return fs.WalkDir(w.fsys, ".", func(path string, d fs.DirEntry, pathErr error) error {
+		if pathErr != nil {
+			return pathErr
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if path != "." && (w.filter.SkipDir(path) || w.ignores.Ignored(path, true)) {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if w.filter.SkipFile(path) || w.ignores.Ignored(path, false) {
+			return nil
+		}
+
+		return fn(path, "/"+path)
+	})
+}
+
+type fileJob struct {
+	index int
+	path  string
+	name  string
+}
+
+// fileEntry is what was found in one file. A file found by the walker can
+// hold several of them if it's an archive.
+type fileEntry[T any] struct {
+	name  string
+	value T
+}
+
+type fileResult[T any] struct {
+	index   int
+	entries []fileEntry[T]
+	err     error
+}
+
+// searchFunc searches the contents of a file, read from rd, whose name is in
+// the same form as search results.
+type searchFunc[T any] func(ctx context.Context, rd io.Reader, name string) (T, error)
+
+// searchFile opens the file at path and searches it, or if it's an archive
+// and w is set up to look inside them, each file in it. Compressed files are
+// decompressed first if w is set up for that, and everything is transcoded to
+// UTF-8 as the search options say. Files that turn out not to be readable
+// that way, like truncated gzip files, are skipped, as rg does.
+func searchFile[T any](ctx context.Context, w *walker, path, name string, search searchFunc[T]) ([]fileEntry[T], error) {
+	fd, err := w.fsys.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("native.searchFile: could not open file: %w", err)
+	}
+	defer fd.Close()
+
+	var entries []fileEntry[T]
+
+	add := func(rd io.Reader, name string) error {
+		if w.decompress {
+			var err error
+			if rd, err = decompress(rd, name); err != nil {
+				return err
+			}
+		}
+
+		rd = transcode(rd, w.encoding)
+
+		value, err := search(ctx, rd, name)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, fileEntry[T]{name: name, value: value})
+
+		return nil
+	}
+
+	if w.archives != nil && archiveKindOf(name) != notArchive {
+		err = w.archives.walk(ctx, fd, name, add)
+	} else {
+		err = add(fd, name)
+	}
+	if unreadable := (*unreadableError)(nil); errors.As(err, &unreadable) && ctx.Err() == nil {
+		if w.skipped != nil {
+			w.skipped(name, err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("native.searchFile: %w", err)
+	}
+
+	if err := fd.Close(); err != nil {
+		return nil, fmt.Errorf("native.searchFile: could not close file: %w", err)
+	}
+
+	return entries, nil
+}
+
+// searchFiles runs search on every file the walker finds, using up to workers
+// goroutines, and passes the results to emit in the order the files were
+// found. If search or emit return an error, everything is stopped and that
+// error is returned.
+func searchFiles[T any](ctx context.Context, workers int, w *walker, search searchFunc[T], emit func(name string, value T) error) error {
+	parent := ctx
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan fileJob)
+	results := make(chan fileResult[T], workers)
+
+	// This bounds how far the walk can get ahead of emit, which in turn bounds
+	// how many results are held while waiting for an earlier file to finish.
+	inflight := make(chan struct{}, workers*16)
+
+	walkDone := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+
+		var index int
+		walkDone <- w.walk(ctx, func(path, name string) error {
+			select {
+			case inflight <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			select {
+			case jobs <- fileJob{index: index, path: path, name: name}:
+				index++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				entries, err := searchFile(ctx, w, job.path, job.name, search)
+				if err != nil {
+					err = fmt.Errorf("could not search file %q: %w", job.path, err)
+				}
+
+				results <- fileResult[T]{index: job.index, entries: entries, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+
+	pending := make(map[int]fileResult[T])
+	next := 0
+
+	for r := range results {
+		if err != nil {
+			continue
+		}
+
+		pending[r.index] = r
+
+		for {
+			p, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+			<-inflight
+
+			err = p.err
+			for _, e := range p.entries {
+				if err != nil {
+					break
+				}
+				err = emit(e.name, e.value)
+			}
+
+			if err != nil {
+				cancel()
+				break
+			}
+		}
+	}
+
+	walkErr := <-walkDone
+
+	if ctxErr := parent.Err(); ctxErr != nil {
+		return fmt.Errorf("native.searchFiles: %w", ctxErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("native.searchFiles: %w", err)
+	}
+
+	if walkErr != nil {
+		return fmt.Errorf("native.searchFiles: could not walk directory: %w", walkErr)
+	}
+
+	return nil
+}
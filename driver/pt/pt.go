@@ -6,11 +6,20 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"syscall"
 
 	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/capability"
+	"fknsrs.biz/p/searchfiles/internal/glob"
+	"fknsrs.biz/p/searchfiles/internal/ignore"
+	"fknsrs.biz/p/searchfiles/internal/matchline"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
 	"fknsrs.biz/p/searchfiles/internal/runctx"
+	"fknsrs.biz/p/searchfiles/internal/sniff"
+	"fknsrs.biz/p/searchfiles/internal/version"
 )
 
 var (
@@ -26,8 +35,17 @@ const (
 	DefaultProgram = "pt"
 )
 
+// versionFlavors is how pt says what version it is.
+var versionFlavors = []version.Flavor{
+	{Pattern: regexp.MustCompile(`pt version v?(\d+(?:\.\d+)*)`)},
+}
+
 type Driver struct {
 	Program string
+	// Decompress makes searches look at the decompressed contents of
+	// compressed files, with pt --search-zip. Older versions of pt don't
+	// have it, and searches with them fail with searchfiles.ErrUnimplemented.
+	Decompress bool
 }
 
 func (d *Driver) program() string {
@@ -45,71 +63,407 @@ func (d *Driver) SelfTest(ctx context.Context) error {
 	return nil
 }
 
-func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
-	if st, err := os.Stat(directory); err != nil {
-		return nil, fmt.Errorf("pt.Driver.SearchLiteral: could not stat %q: %w", directory, err)
-	} else if !st.IsDir() {
-		return nil, fmt.Errorf("pt.Driver.SearchLiteral: %q is not a directory", directory)
+func (d *Driver) Version(ctx context.Context) (searchfiles.Version, error) {
+	v, err := version.Probe(ctx, d.program(), versionFlavors)
+	if err != nil {
+		return searchfiles.Version{}, fmt.Errorf("pt.Driver.Version: %w", err)
 	}
 
-	files, err := d.search(ctx, query, directory)
+	return v, nil
+}
+
+// Capabilities reads pt --help to find out what the installed version can
+// do.
+func (d *Driver) Capabilities(ctx context.Context) (searchfiles.Capabilities, error) {
+	c, err := capability.Cached(ctx, d.program(), func(ctx context.Context) (searchfiles.Capabilities, error) {
+		return capability.Help(ctx, d.program(), searchfiles.CapabilityRegexp, map[searchfiles.Capabilities][]string{
+			searchfiles.CapabilityVCSIgnore:  {"--skip-vcs-ignores"},
+			searchfiles.CapabilityDecompress: {"--search-zip"},
+		})
+	})
 	if err != nil {
+		return 0, fmt.Errorf("pt.Driver.Capabilities: %w", err)
+	}
+
+	return c, nil
+}
+
+func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	var files []string
+
+	if err := d.StreamLiteral(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("pt.Driver.SearchLiteral: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
 func (d *Driver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
-	if st, err := os.Stat(directory); err != nil {
-		return nil, fmt.Errorf("pt.Driver.SearchRegexp: could not stat %q: %w", directory, err)
-	} else if !st.IsDir() {
-		return nil, fmt.Errorf("pt.Driver.SearchRegexp: %q is not a directory", directory)
-	}
+	var files []string
 
-	files, err := d.search(ctx, "-e", query, directory)
-	if err != nil {
+	if err := d.StreamRegexp(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("pt.Driver.SearchRegexp: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
-func (d *Driver) search(ctx context.Context, args ...string) ([]string, error) {
-	files, err := runctx.Run(ctx, d.program(), append([]string{"-l"}, args...), func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 0 {
-					return nil
-				}
+func (d *Driver) StreamLiteral(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{}, fn); err != nil {
+		return fmt.Errorf("pt.Driver.StreamLiteral: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamRegexp(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true}, fn); err != nil {
+		return fmt.Errorf("pt.Driver.StreamRegexp: %w", err)
+	}
+
+	return nil
+}
 
-				if status.ExitStatus() == 1 && stdout.Len() == 0 && stderr.Len() == 0 {
-					return nil
-				}
+func (d *Driver) StreamWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions, fn searchfiles.StreamFunc) error {
+	if err := checkDirectory(directory); err != nil {
+		return fmt.Errorf("pt.Driver.StreamWithOptions: %w", err)
+	}
+
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return fmt.Errorf("pt.Driver.StreamWithOptions: %w", err)
+	}
+
+	list := "-l"
+	if options.FilesWithoutMatch {
+		list = "-L"
+	}
+
+	args = append([]string{list, "--null"}, args...)
+
+	if err := runctx.StreamSplit(ctx, "", d.program(), append(args, directory), runctx.NUL, checkError, func(line string) error {
+		file := cleanResult(directory, line)
+		if file == "" || skip(file) {
+			return nil
+		}
+		// Binary files count as having no matches, rather than being
+		// skipped like they are everywhere else.
+		if options.FilesWithoutMatch && !options.Binary {
+			if binary, err := sniff.File(filepath.Join(directory, file)); err != nil {
+				return fmt.Errorf("could not check file: %w", err)
+			} else if binary {
+				return nil
 			}
 		}
+		return fn(file)
+	}); err != nil {
+		return fmt.Errorf("pt.Driver.StreamWithOptions: could not run command: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) MatchLiteral(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("pt.Driver.MatchLiteral: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (d *Driver) MatchRegexp(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true})
+	if err != nil {
+		return nil, fmt.Errorf("pt.Driver.MatchRegexp: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (d *Driver) MatchWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]searchfiles.Match, error) {
+	if err := checkDirectory(directory); err != nil {
+		return nil, fmt.Errorf("pt.Driver.MatchWithOptions: %w", err)
+	}
+
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("pt.Driver.MatchWithOptions: %w", err)
+	}
+
+	args = append([]string{"--nogroup", "--nocolor", "--numbers", "--null"}, args...)
+
+	lines, err := d.lines(ctx, append(args, directory))
+	if err != nil {
+		return nil, fmt.Errorf("pt.Driver.MatchWithOptions: could not run command: %w", err)
+	}
+
+	// Submatches are found by re-running the query with Go's regexp package.
+	// If it's not valid there, we still report the matching lines.
+	re, _ := pattern.Compile(query, options)
+	if options.Invert {
+		re = nil
+	}
+
+	matches, err := matchline.ParseAll(directory, lines, false, re)
+	if err != nil {
+		return nil, fmt.Errorf("pt.Driver.MatchWithOptions: could not parse output: %w", err)
+	}
+
+	return filterMatches(skip, matches), nil
+}
+
+func (d *Driver) CountWithOptions(ctx context.Context, directory, query string, mode searchfiles.CountMode, options searchfiles.SearchOptions) (searchfiles.Counts, error) {
+	// pt's --count counts lines, so occurrences are counted from the full
+	// output instead.
+	if mode == searchfiles.CountOccurrences {
+		matches, err := d.MatchWithOptions(ctx, directory, query, options)
+		if err != nil {
+			return nil, fmt.Errorf("pt.Driver.CountWithOptions: %w", err)
+		}
 
-		return err
+		return matchline.Count(matches, true), nil
+	}
+
+	if err := checkDirectory(directory); err != nil {
+		return nil, fmt.Errorf("pt.Driver.CountWithOptions: %w", err)
+	}
+
+	args, skip, err := d.searchArgs(ctx, directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("pt.Driver.CountWithOptions: %w", err)
+	}
+
+	args = append([]string{"--count", "--nocolor", "--null"}, args...)
+
+	counts := searchfiles.Counts{}
+
+	if err := runctx.StreamSplit(ctx, "", d.program(), append(args, directory), runctx.PrefixedLines, checkError, func(line string) error {
+		file, n, err := matchline.ParseCount(directory, line)
+		if err != nil {
+			return fmt.Errorf("could not parse output: %w", err)
+		}
+
+		if n > 0 && !skip(file) {
+			counts[file] = n
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("pt.Driver.CountWithOptions: could not run command: %w", err)
+	}
+
+	return counts, nil
+}
+
+// lines runs pt and returns the lines it prints, each starting with a file
+// name ended by a NUL, as printed by --null.
+func (d *Driver) lines(ctx context.Context, args []string) ([]string, error) {
+	var lines []string
+
+	if err := runctx.StreamSplit(ctx, "", d.program(), args, runctx.PrefixedLines, checkError, func(line string) error {
+		if line != "" {
+			lines = append(lines, line)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("pt.Driver.lines: %w", err)
+	}
+
+	return lines, nil
+}
+
+func (d *Driver) searchArgs(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]string, func(file string) bool, error) {
+	args, err := filterArgs(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pt.Driver.searchArgs: %w", err)
+	}
+
+	ignoreArgs, err := ignoreArgs(options.Ignore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pt.Driver.searchArgs: %w", err)
+	}
+
+	queryArgs, err := queryArgs(query, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pt.Driver.searchArgs: %w", err)
+	}
+
+	// Not every glob can be passed on to pt, so the results are checked
+	// against all of them afterwards. pt also doesn't read .ignore files, so
+	// those are handled here too.
+	filter, err := glob.NewFilter(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pt.Driver.searchArgs: %w", err)
+	}
+
+	ignores := ignore.New(directory, searchfiles.IgnorePolicy{
+		NoVCSIgnore:   true,
+		NoIgnoreFiles: options.Ignore.NoIgnoreFiles,
+		Hidden:        true,
 	})
+
+	skip := func(file string) bool {
+		name := glob.Relative(file)
+		return filter.SkipPath(name) || ignores.SkipPath(name)
+	}
+
+	args = append(args, ignoreArgs...)
+
+	if d.Decompress {
+		c, err := d.Capabilities(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pt.Driver.searchArgs: %w", err)
+		}
+		if !c.Has(searchfiles.CapabilityDecompress) {
+			return nil, nil, fmt.Errorf("pt.Driver.searchArgs: searching compressed files: %w", searchfiles.ErrUnimplemented)
+		}
+		args = append(args, "--search-zip")
+	}
+
+	return append(args, queryArgs...), skip, nil
+}
+
+func filterArgs(options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	typeGlobs, err := glob.TypeGlobs(options.Types)
 	if err != nil {
-		return nil, fmt.Errorf("pt.Driver.search: could not run command: %w", err)
+		return nil, fmt.Errorf("pt.filterArgs: %w", err)
 	}
 
-	return files, nil
+	// -G takes a single regexp, so it has to cover every file that might
+	// match. If any of the globs can't be expressed that way, it's left out.
+	include, err := glob.NameExpression(append(append([]string(nil), options.Include...), typeGlobs...))
+	if err != nil {
+		return nil, fmt.Errorf("pt.filterArgs: %w", err)
+	}
+	if include != "" {
+		args = append(args, "-G", include)
+	}
+
+	// --ignore applies to both files and directories, so directory-only globs
+	// are left to the filter.
+	for _, e := range options.Exclude {
+		if glob.IsBase(e) && !strings.HasSuffix(e, "/") {
+			args = append(args, "--ignore", e)
+		}
+	}
+
+	return args, nil
 }
 
-func cleanResults(directory string, input []string) []string {
-	var files []string
+func ignoreArgs(policy searchfiles.IgnorePolicy) ([]string, error) {
+	var args []string
+
+	if policy.NoVCSIgnore {
+		args = append(args, "--skip-vcs-ignores")
+	}
+	if policy.Hidden {
+		args = append(args, "--hidden")
+	}
 
-	for _, e := range input {
-		e = strings.TrimSpace(e)
-		e = strings.TrimPrefix(e, directory)
-		if e == "" {
-			continue
+	return args, nil
+}
+
+func queryArgs(query string, options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	// pt uses Go's regexp package already, but the query still has to be
+	// checked before it's run, and ^ and $ written to match at each line.
+	original := query
+	if options.Regexp {
+		var err error
+		if query, err = pattern.Translate(query, pattern.RE2); err != nil {
+			return nil, fmt.Errorf("pt.queryArgs: %w", err)
 		}
+	}
 
-		files = append(files, e)
+	if options.Invert {
+		return nil, fmt.Errorf("pt.queryArgs: inverted matching: %w", searchfiles.ErrUnimplemented)
+	}
+	// pt always skips binary files.
+	if options.Binary {
+		return nil, fmt.Errorf("pt.queryArgs: searching binary files: %w", searchfiles.ErrUnimplemented)
 	}
+	// pt only ever matches a line at a time.
+	if options.Multiline {
+		return nil, fmt.Errorf("pt.queryArgs: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+	// pt searches bytes as they are, which is only right for UTF-8.
+	if options.Encoding != searchfiles.EncodingAuto && options.Encoding != searchfiles.EncodingUTF8 {
+		return nil, fmt.Errorf("pt.queryArgs: transcoding files: %w", searchfiles.ErrUnimplemented)
+	}
+
+	// pt has no --line-regexp, so whole line matches are done by anchoring
+	// the query, which means it always has to be treated as a regexp.
+	if options.WholeLine {
+		if !options.Regexp {
+			query = regexp.QuoteMeta(query)
+		}
+		query = "^(?:" + query + ")$"
+		args = append(args, "-e")
+	} else if options.Regexp {
+		args = append(args, "-e")
+	}
+	// The query has been translated by now, which can add upper case
+	// letters, so pt's --smart-case would get it wrong.
+	if pattern.ResolveCase([]string{original}, options).CaseInsensitive {
+		args = append(args, "--ignore-case")
+	}
+	if options.WholeWord && !options.WholeLine {
+		args = append(args, "--word-regexp")
+	}
+
+	return append(args, query), nil
+}
+
+// pt happily reports no matches for directories that don't exist, so we
+// check for them ourselves.
+func checkDirectory(directory string) error {
+	if st, err := os.Stat(directory); err != nil {
+		return fmt.Errorf("pt.checkDirectory: could not stat %q: %w", directory, err)
+	} else if !st.IsDir() {
+		return fmt.Errorf("pt.checkDirectory: %q is not a directory", directory)
+	}
+
+	return nil
+}
+
+func filterMatches(skip func(file string) bool, matches []searchfiles.Match) []searchfiles.Match {
+	var a []searchfiles.Match
+
+	for _, e := range matches {
+		if !skip(e.Path) {
+			a = append(a, e)
+		}
+	}
+
+	return a
+}
+
+func checkError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.ExitStatus() == 0 {
+				return nil
+			}
+
+			if status.ExitStatus() == 1 && stderr.Len() == 0 {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
 
-	return files
+func cleanResult(directory, line string) string {
+	return strings.TrimPrefix(line, directory)
 }
@@ -0,0 +1,112 @@
+package rg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// jsonEvent is one line of the output of rg --json. Only the fields we use
+// are decoded.
+type jsonEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Path           jsonText `json:"path"`
+		Lines          jsonText `json:"lines"`
+		LineNumber     int      `json:"line_number"`
+		AbsoluteOffset int64    `json:"absolute_offset"`
+		Submatches     []struct {
+			Match jsonText `json:"match"`
+			Start int      `json:"start"`
+			End   int      `json:"end"`
+		} `json:"submatches"`
+		// Stats is only set on end and summary events.
+		Stats struct {
+			MatchedLines int `json:"matched_lines"`
+			Matches      int `json:"matches"`
+		} `json:"stats"`
+	} `json:"data"`
+}
+
+// jsonText is how rg represents data that might not be valid UTF-8. It's
+// either plain text, or base64-encoded bytes if it can't be.
+type jsonText struct {
+	Text  *string `json:"text"`
+	Bytes *string `json:"bytes"`
+}
+
+func (t jsonText) decode() (string, error) {
+	if t.Text != nil {
+		return *t.Text, nil
+	}
+
+	if t.Bytes != nil {
+		b, err := base64.StdEncoding.DecodeString(*t.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("rg.jsonText.decode: %w", err)
+		}
+
+		return string(b), nil
+	}
+
+	return "", nil
+}
+
+func parseEvent(line string) (*jsonEvent, error) {
+	var e jsonEvent
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		return nil, fmt.Errorf("rg.parseEvent: %w", err)
+	}
+
+	return &e, nil
+}
+
+// path returns the path of the file the event is about, relative to
+// directory.
+func (e *jsonEvent) path(directory string) (string, error) {
+	path, err := e.Data.Path.decode()
+	if err != nil {
+		return "", fmt.Errorf("rg.jsonEvent.path: %w", err)
+	}
+
+	return strings.TrimPrefix(path, directory), nil
+}
+
+// match turns a match or context event into a Match, with its path made
+// relative to directory.
+func (e *jsonEvent) match(directory string) (searchfiles.Match, error) {
+	path, err := e.path(directory)
+	if err != nil {
+		return searchfiles.Match{}, fmt.Errorf("rg.jsonEvent.match: %w", err)
+	}
+
+	line, err := e.Data.Lines.decode()
+	if err != nil {
+		return searchfiles.Match{}, fmt.Errorf("rg.jsonEvent.match: could not decode line: %w", err)
+	}
+
+	m := searchfiles.Match{
+		Path:       path,
+		LineNumber: e.Data.LineNumber,
+		Offset:     e.Data.AbsoluteOffset,
+		Line:       strings.TrimSuffix(line, "\n"),
+	}
+
+	for _, sm := range e.Data.Submatches {
+		text, err := sm.Match.decode()
+		if err != nil {
+			return searchfiles.Match{}, fmt.Errorf("rg.jsonEvent.match: could not decode submatch: %w", err)
+		}
+
+		m.Submatches = append(m.Submatches, searchfiles.Submatch{Start: sm.Start, End: sm.End, Text: text})
+	}
+
+	if len(m.Submatches) > 0 {
+		m.Column = m.Submatches[0].Start + 1
+	}
+
+	return m, nil
+}
@@ -0,0 +1,60 @@
+package rg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+func TestParseEvent(t *testing.T) {
+	a := assert.New(t)
+
+	for _, tc := range []struct {
+		name  string
+		input string
+		match searchfiles.Match
+	}{
+		{
+			"Text",
+			`{"type":"match","data":{"path":{"text":"/root/ a.txt "},"lines":{"text":"x match\n"},"line_number":3,"absolute_offset":10,"submatches":[{"match":{"text":"match"},"start":2,"end":7}]}}`,
+			searchfiles.Match{Path: "/ a.txt ", LineNumber: 3, Offset: 10, Column: 3, Line: "x match", Submatches: []searchfiles.Submatch{{Start: 2, End: 7, Text: "match"}}},
+		},
+		{
+			"Bytes",
+			`{"type":"match","data":{"path":{"bytes":"L3Jvb3QvYgr/LnR4dA=="},"lines":{"bytes":"eP9tYXRjaAo="},"line_number":1,"absolute_offset":0,"submatches":[{"match":{"text":"match"},"start":2,"end":7}]}}`,
+			searchfiles.Match{Path: "/b\n\xff.txt", LineNumber: 1, Offset: 0, Column: 3, Line: "x\xffmatch", Submatches: []searchfiles.Submatch{{Start: 2, End: 7, Text: "match"}}},
+		},
+		{
+			"Context",
+			`{"type":"context","data":{"path":{"text":"/root/c.txt"},"lines":{"text":"before\n"},"line_number":1,"absolute_offset":0,"submatches":[]}}`,
+			searchfiles.Match{Path: "/c.txt", LineNumber: 1, Offset: 0, Line: "before"},
+		},
+	} {
+		e, err := parseEvent(tc.input)
+		if !a.NoError(err, tc.name) {
+			continue
+		}
+
+		m, err := e.match("/root")
+		if a.NoError(err, tc.name) {
+			a.Equal(tc.match, m, tc.name)
+		}
+	}
+}
+
+func TestParseEventStats(t *testing.T) {
+	a := assert.New(t)
+
+	e, err := parseEvent(`{"type":"end","data":{"path":{"text":"/root/f.txt"},"binary_offset":null,"stats":{"elapsed":{"secs":0,"nanos":31910,"human":"0.000032s"},"searches":1,"searches_with_match":1,"bytes_searched":2,"bytes_printed":229,"matched_lines":2,"matches":3}}}`)
+	if a.NoError(err) {
+		a.Equal("end", e.Type)
+		a.Equal(2, e.Data.Stats.MatchedLines)
+		a.Equal(3, e.Data.Stats.Matches)
+
+		path, err := e.path("/root")
+		a.NoError(err)
+		a.Equal("/f.txt", path)
+	}
+}
@@ -4,12 +4,22 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"syscall"
 
 	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/capability"
+	"fknsrs.biz/p/searchfiles/internal/glob"
+	"fknsrs.biz/p/searchfiles/internal/matchline"
+	"fknsrs.biz/p/searchfiles/internal/multi"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
 	"fknsrs.biz/p/searchfiles/internal/runctx"
+	"fknsrs.biz/p/searchfiles/internal/version"
 )
 
 var (
@@ -25,8 +35,16 @@ const (
 	DefaultProgram = "rg"
 )
 
+// versionFlavors is how rg says what version it is.
+var versionFlavors = []version.Flavor{
+	{Pattern: regexp.MustCompile(`ripgrep (\d+(?:\.\d+)*)`)},
+}
+
 type Driver struct {
 	Program string
+	// Decompress makes searches look at the decompressed contents of
+	// compressed files, with rg --search-zip.
+	Decompress bool
 }
 
 func (d *Driver) program() string {
@@ -44,59 +62,563 @@ func (d *Driver) SelfTest(ctx context.Context) error {
 	return nil
 }
 
-func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
-	files, err := d.search(ctx, "--fixed-strings", query, directory)
+func (d *Driver) Version(ctx context.Context) (searchfiles.Version, error) {
+	v, err := version.Probe(ctx, d.program(), versionFlavors)
 	if err != nil {
+		return searchfiles.Version{}, fmt.Errorf("rg.Driver.Version: %w", err)
+	}
+
+	return v, nil
+}
+
+// Capabilities reads rg --help to find out what the installed version can
+// do.
+func (d *Driver) Capabilities(ctx context.Context) (searchfiles.Capabilities, error) {
+	c, err := capability.Cached(ctx, d.program(), func(ctx context.Context) (searchfiles.Capabilities, error) {
+		return capability.Help(ctx, d.program(), searchfiles.CapabilityRegexp|searchfiles.CapabilityInvert|searchfiles.CapabilityBinary, map[searchfiles.Capabilities][]string{
+			searchfiles.CapabilityMultiline:  {"--multiline"},
+			searchfiles.CapabilityEncoding:   {"--encoding"},
+			searchfiles.CapabilityVCSIgnore:  {"--no-require-git", "--no-ignore-exclude", "--no-ignore-vcs"},
+			searchfiles.CapabilityDecompress: {"--search-zip"},
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("rg.Driver.Capabilities: %w", err)
+	}
+
+	return c, nil
+}
+
+func (d *Driver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	var files []string
+
+	if err := d.StreamLiteral(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("rg.Driver.SearchLiteral: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
 }
 
 func (d *Driver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
-	files, err := d.search(ctx, query, directory)
-	if err != nil {
+	var files []string
+
+	if err := d.StreamRegexp(ctx, directory, query, func(file string) error {
+		files = append(files, file)
+		return nil
+	}); err != nil {
 		return nil, fmt.Errorf("rg.Driver.SearchRegexp: %w", err)
 	}
 
-	return cleanResults(directory, files), nil
+	return files, nil
+}
+
+func (d *Driver) StreamLiteral(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{}, fn); err != nil {
+		return fmt.Errorf("rg.Driver.StreamLiteral: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamRegexp(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	if err := d.StreamWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true}, fn); err != nil {
+		return fmt.Errorf("rg.Driver.StreamRegexp: %w", err)
+	}
+
+	return nil
 }
 
-func (d *Driver) search(ctx context.Context, args ...string) ([]string, error) {
-	files, err := runctx.Run(ctx, d.program(), append([]string{"--files-with-matches"}, args...), func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-				if status.ExitStatus() == 0 {
-					return nil
-				}
+func (d *Driver) StreamWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions, fn searchfiles.StreamFunc) error {
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return fmt.Errorf("rg.Driver.StreamWithOptions: %w", err)
+	}
+
+	// --json can't be combined with --files-without-match, as there'd be no
+	// events for the files it lists.
+	if options.FilesWithoutMatch {
+		args = append([]string{"--files-without-match", "--null"}, args...)
 
-				if status.ExitStatus() == 1 && stdout.Len() == 0 && stderr.Len() == 0 {
-					return nil
-				}
+		if err := d.run(ctx, directory, args, runctx.NUL, func(directory, line string) error {
+			file := cleanResult(directory, line)
+			if file == "" || skip(file) {
+				return nil
 			}
+			return fn(file)
+		}); err != nil {
+			return fmt.Errorf("rg.Driver.StreamWithOptions: %w", err)
 		}
 
-		return err
-	})
+		return nil
+	}
+
+	// A file's begin event comes just before its first match, and that's
+	// all we need from it.
+	args = append([]string{"--max-count=1"}, args...)
+
+	if err := d.runJSON(ctx, directory, args, func(directory string, e *jsonEvent) error {
+		if e.Type != "begin" {
+			return nil
+		}
+
+		file, err := e.path(directory)
+		if err != nil {
+			return err
+		}
+		if skip(file) {
+			return nil
+		}
+		return fn(file)
+	}); err != nil {
+		return fmt.Errorf("rg.Driver.StreamWithOptions: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) MatchLiteral(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("rg.Driver.search: could not run command: %w", err)
+		return nil, fmt.Errorf("rg.Driver.MatchLiteral: %w", err)
 	}
 
-	return files, nil
+	return matches, nil
 }
 
-func cleanResults(directory string, input []string) []string {
-	var files []string
+func (d *Driver) MatchRegexp(ctx context.Context, directory, query string) ([]searchfiles.Match, error) {
+	matches, err := d.MatchWithOptions(ctx, directory, query, searchfiles.SearchOptions{Regexp: true})
+	if err != nil {
+		return nil, fmt.Errorf("rg.Driver.MatchRegexp: %w", err)
+	}
+
+	return matches, nil
+}
+
+func (d *Driver) MatchWithOptions(ctx context.Context, directory, query string, options searchfiles.SearchOptions) ([]searchfiles.Match, error) {
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return nil, fmt.Errorf("rg.Driver.MatchWithOptions: %w", err)
+	}
+
+	var matches []searchfiles.Match
 
-	for _, e := range input {
-		e = strings.TrimSpace(e)
-		e = strings.TrimPrefix(e, directory)
-		if e == "" {
-			continue
+	if err := d.runJSON(ctx, directory, args, func(directory string, e *jsonEvent) error {
+		if e.Type != "match" {
+			return nil
 		}
 
-		files = append(files, e)
+		m, err := e.match(directory)
+		if err != nil {
+			return err
+		}
+
+		if !skip(m.Path) {
+			matches = append(matches, m)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("rg.Driver.MatchWithOptions: %w", err)
 	}
 
-	return files
+	return matches, nil
+}
+
+func (d *Driver) MatchWithContext(ctx context.Context, directory, query string, before, after int, options searchfiles.SearchOptions) ([]searchfiles.ContextMatch, error) {
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return nil, fmt.Errorf("rg.Driver.MatchWithContext: %w", err)
+	}
+
+	args = append([]string{fmt.Sprintf("--before-context=%d", before), fmt.Sprintf("--after-context=%d", after)}, args...)
+
+	b := matchline.NewContextBuilder(before, after)
+
+	if err := d.runJSON(ctx, directory, args, func(directory string, e *jsonEvent) error {
+		if e.Type != "match" && e.Type != "context" {
+			return nil
+		}
+
+		m, err := e.match(directory)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case skip(m.Path):
+		case e.Type == "context":
+			b.Context(m.Path, m.LineNumber, m.Line)
+		default:
+			b.Match(m)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("rg.Driver.MatchWithContext: %w", err)
+	}
+
+	return b.Matches(), nil
+}
+
+func (d *Driver) CountWithOptions(ctx context.Context, directory, query string, mode searchfiles.CountMode, options searchfiles.SearchOptions) (searchfiles.Counts, error) {
+	args, skip, err := searchArgs(directory, []string{query}, options)
+	if err != nil {
+		return nil, fmt.Errorf("rg.Driver.CountWithOptions: %w", err)
+	}
+
+	occurrences := mode == searchfiles.CountOccurrences && !options.Invert
+
+	counts := searchfiles.Counts{}
+
+	// Each file's end event has both counts in its stats.
+	if err := d.runJSON(ctx, directory, args, func(directory string, e *jsonEvent) error {
+		if e.Type != "end" {
+			return nil
+		}
+
+		file, err := e.path(directory)
+		if err != nil {
+			return err
+		}
+
+		n := e.Data.Stats.MatchedLines
+		if occurrences {
+			n = e.Data.Stats.Matches
+		}
+
+		if n > 0 && !skip(file) {
+			counts[file] = n
+		}
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("rg.Driver.CountWithOptions: %w", err)
+	}
+
+	return counts, nil
+}
+
+// run runs rg from inside directory, as that's what globs containing a slash
+// are matched relative to. The absolute path of directory is passed on to fn,
+// as that's what the output is prefixed with, along with each record of the
+// output as broken up by split.
+func (d *Driver) run(ctx context.Context, directory string, args []string, split runctx.Split, fn func(directory, record string) error) error {
+	absolute, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("rg.Driver.run: could not resolve %q: %w", directory, err)
+	}
+
+	if d.Decompress {
+		c, err := d.Capabilities(ctx)
+		if err != nil {
+			return fmt.Errorf("rg.Driver.run: %w", err)
+		}
+		if !c.Has(searchfiles.CapabilityDecompress) {
+			return fmt.Errorf("rg.Driver.run: searching compressed files: %w", searchfiles.ErrUnimplemented)
+		}
+		args = append([]string{"--search-zip"}, args...)
+	}
+
+	if err := runctx.StreamSplit(ctx, absolute, d.program(), append(args, absolute), split, checkError, func(record string) error {
+		return fn(absolute, record)
+	}); err != nil {
+		return fmt.Errorf("rg.Driver.run: could not run command: %w", err)
+	}
+
+	return nil
+}
+
+// runJSON is like run, but with --json, and passes on each event rather than
+// each record. Events are one per line, and never contain a raw newline.
+func (d *Driver) runJSON(ctx context.Context, directory string, args []string, fn func(directory string, e *jsonEvent) error) error {
+	if err := d.run(ctx, directory, append([]string{"--json"}, args...), runctx.Lines, func(directory, line string) error {
+		if line == "" {
+			return nil
+		}
+
+		e, err := parseEvent(line)
+		if err != nil {
+			return fmt.Errorf("could not parse output: %w", err)
+		}
+
+		return fn(directory, e)
+	}); err != nil {
+		return fmt.Errorf("rg.Driver.runJSON: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Driver) StreamMulti(ctx context.Context, directory string, queries []string, mode searchfiles.MultiMode, options searchfiles.SearchOptions, fn searchfiles.MultiFunc) error {
+	if options.Invert {
+		return fmt.Errorf("rg.Driver.StreamMulti: inverted matching: %w", searchfiles.ErrUnimplemented)
+	}
+	if options.Multiline {
+		return fmt.Errorf("rg.Driver.StreamMulti: multiline matching: %w", searchfiles.ErrUnimplemented)
+	}
+
+	// Smart case has to be settled before any arguments are worked out, so
+	// that rg and the collector agree on it.
+	options = pattern.ResolveCase(queries, options)
+
+	set, err := multi.Compile(queries, options)
+	if err != nil {
+		return fmt.Errorf("rg.Driver.StreamMulti: could not compile queries: %w", err)
+	}
+
+	if len(queries) == 0 {
+		return nil
+	}
+
+	args, skip, err := searchArgs(directory, nil, options)
+	if err != nil {
+		return fmt.Errorf("rg.Driver.StreamMulti: %w", err)
+	}
+
+	translated, err := translate(queries, options)
+	if err != nil {
+		return fmt.Errorf("rg.Driver.StreamMulti: %w", err)
+	}
+
+	patternArgs, cleanup, err := patternArgs(translated)
+	if err != nil {
+		return fmt.Errorf("rg.Driver.StreamMulti: %w", err)
+	}
+	defer cleanup()
+
+	args = append(args, patternArgs...)
+
+	// rg can only tell us which lines matched, not which patterns they
+	// matched, so that's worked out again from the lines.
+	c := multi.NewCollector(set, mode, fn)
+
+	if err := d.runJSON(ctx, directory, args, func(directory string, e *jsonEvent) error {
+		if e.Type != "match" {
+			return nil
+		}
+
+		m, err := e.match(directory)
+		if err != nil {
+			return err
+		}
+		if skip(m.Path) {
+			return nil
+		}
+		return c.Line(m.Path, m.Line)
+	}); err != nil {
+		return fmt.Errorf("rg.Driver.StreamMulti: %w", err)
+	}
+
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("rg.Driver.StreamMulti: %w", err)
+	}
+
+	return nil
+}
+
+func searchArgs(directory string, queries []string, options searchfiles.SearchOptions) ([]string, func(file string) bool, error) {
+	args, err := filterArgs(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rg.searchArgs: %w", err)
+	}
+
+	ignoreArgs, err := ignoreArgs(options.Ignore)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rg.searchArgs: %w", err)
+	}
+
+	queryArgs, err := queryArgs(queries, options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rg.searchArgs: %w", err)
+	}
+
+	// rg's handling of globs and types is close to ours, but not identical,
+	// so the results are checked again afterwards.
+	filter, err := glob.NewFilter(options)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rg.searchArgs: %w", err)
+	}
+
+	skip := func(file string) bool {
+		return filter.SkipPath(glob.Relative(file))
+	}
+
+	args = append(args, ignoreArgs...)
+
+	return append(args, queryArgs...), skip, nil
+}
+
+func filterArgs(options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	for _, e := range options.Include {
+		args = append(args, "--glob", e)
+	}
+	for _, e := range options.Exclude {
+		args = append(args, "--glob", "!"+e)
+	}
+	for _, e := range options.Types {
+		globs, err := glob.TypeGlobs([]string{e})
+		if err != nil {
+			return nil, fmt.Errorf("rg.filterArgs: %w", err)
+		}
+
+		// rg has its own idea of what each type is, so we replace it.
+		args = append(args, "--type-clear", e)
+		for _, g := range globs {
+			args = append(args, "--type-add", e+":"+g)
+		}
+		args = append(args, "--type", e)
+	}
+
+	return args, nil
+}
+
+func ignoreArgs(policy searchfiles.IgnorePolicy) ([]string, error) {
+	// Only the ignore files the other drivers know about are used, and only
+	// from within the searched directory, regardless of whether it's in a
+	// git repository.
+	args := []string{"--no-require-git", "--no-ignore-parent", "--no-ignore-global", "--no-ignore-exclude"}
+
+	if policy.NoVCSIgnore {
+		args = append(args, "--no-ignore-vcs")
+	}
+	if policy.NoIgnoreFiles {
+		args = append(args, "--no-ignore-dot")
+	}
+	if policy.Hidden {
+		args = append(args, "--hidden")
+	}
+
+	return args, nil
+}
+
+func queryArgs(queries []string, options searchfiles.SearchOptions) ([]string, error) {
+	var args []string
+
+	if !options.Regexp {
+		args = append(args, "--fixed-strings")
+	}
+	// rg's --smart-case would look at the translated queries, which can
+	// have upper case letters the originals don't, so it's settled here.
+	if pattern.ResolveCase(queries, options).CaseInsensitive {
+		args = append(args, "--ignore-case")
+	} else {
+		args = append(args, "--case-sensitive")
+	}
+	if options.WholeLine {
+		args = append(args, "--line-regexp")
+	} else if options.WholeWord {
+		args = append(args, "--word-regexp")
+	}
+	if options.Invert {
+		if options.Multiline {
+			return nil, fmt.Errorf("rg.queryArgs: inverted multiline matching: %w", searchfiles.ErrUnimplemented)
+		}
+		args = append(args, "--invert-match")
+	}
+	if options.Multiline {
+		args = append(args, "--multiline")
+	}
+	if options.Binary {
+		args = append(args, "--text")
+	}
+	switch options.Encoding {
+	case searchfiles.EncodingUTF8:
+		args = append(args, "--encoding=utf-8")
+	case searchfiles.EncodingLatin1:
+		args = append(args, "--encoding=latin1")
+	case searchfiles.EncodingUTF16LE:
+		args = append(args, "--encoding=utf-16le")
+	case searchfiles.EncodingUTF16BE:
+		args = append(args, "--encoding=utf-16be")
+	}
+
+	queries, err := translate(queries, options)
+	if err != nil {
+		return nil, fmt.Errorf("rg.queryArgs: %w", err)
+	}
+
+	for _, query := range queries {
+		args = append(args, "--regexp="+query)
+	}
+
+	return args, nil
+}
+
+// translate rewrites regexp queries in the syntax rg uses, so they match what
+// they would anywhere else.
+func translate(queries []string, options searchfiles.SearchOptions) ([]string, error) {
+	if !options.Regexp {
+		return queries, nil
+	}
+
+	translated := make([]string, len(queries))
+	for i, query := range queries {
+		var err error
+		if translated[i], err = pattern.Translate(query, pattern.Rust); err != nil {
+			return nil, fmt.Errorf("rg.translate: %w", err)
+		}
+	}
+
+	return translated, nil
+}
+
+// patternFileThreshold is the number of patterns beyond which they're
+// written to a file and passed with --file, rather than one by one with
+// --regexp.
+const patternFileThreshold = 32
+
+func patternArgs(queries []string) ([]string, func(), error) {
+	if len(queries) <= patternFileThreshold {
+		var args []string
+		for _, query := range queries {
+			args = append(args, "--regexp="+query)
+		}
+
+		return args, func() {}, nil
+	}
+
+	fd, err := os.CreateTemp("", "searchfiles-patterns-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("rg.patternArgs: could not create pattern file: %w", err)
+	}
+
+	cleanup := func() { os.Remove(fd.Name()) }
+
+	if _, err := io.WriteString(fd, strings.Join(queries, "\n")+"\n"); err != nil {
+		fd.Close()
+		cleanup()
+		return nil, nil, fmt.Errorf("rg.patternArgs: could not write pattern file: %w", err)
+	}
+
+	if err := fd.Close(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("rg.patternArgs: could not close pattern file: %w", err)
+	}
+
+	return []string{"--file=" + fd.Name()}, cleanup, nil
+}
+
+func checkError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.ExitStatus() == 0 {
+				return nil
+			}
+
+			if status.ExitStatus() == 1 && stderr.Len() == 0 {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+func cleanResult(directory, line string) string {
+	return strings.TrimPrefix(line, directory)
 }
@@ -1,8 +1,14 @@
 package rg
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
 	"fknsrs.biz/p/searchfiles/tests"
 )
 
@@ -10,6 +16,23 @@ func TestShared(t *testing.T) {
 	tests.Test_All(Default, t)
 }
 
+func TestDecompress(t *testing.T) {
+	tests.Test_Decompress(&Driver{Decompress: true}, t)
+}
+
+func TestDecompressUnsupported(t *testing.T) {
+	a := assert.New(t)
+
+	// A stand-in for an rg without --search-zip.
+	program := filepath.Join(t.TempDir(), "rg")
+	if err := os.WriteFile(program, []byte("#!/bin/sh\necho '--multiline --encoding'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := (&Driver{Program: program, Decompress: true}).SearchLiteral(context.Background(), ".", "needle")
+	a.ErrorIs(err, searchfiles.ErrUnimplemented)
+}
+
 func BenchmarkShared(b *testing.B) {
 	tests.Benchmark_All(Default, b)
 }
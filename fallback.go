@@ -0,0 +1,157 @@
+package searchfiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Trace has hooks that are called while a search runs, to find out which
+// drivers it used. Any of them can be nil. Add one to a context with
+// WithTrace.
+type Trace struct {
+	// DriverFailed is called when a driver fails to run, before the search
+	// moves on to the next driver in the fallback order.
+	DriverFailed func(driverName string, err error)
+	// DriverServed is called with the name of the driver the results of a
+	// successful search came from.
+	DriverServed func(driverName string)
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context that calls the hooks in trace for searches
+// made with it.
+func WithTrace(ctx context.Context, trace *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+func traceFrom(ctx context.Context) *Trace {
+	if trace, ok := ctx.Value(traceKey{}).(*Trace); ok {
+		return trace
+	}
+
+	return &Trace{}
+}
+
+// ServedBy calls search, which should make a single search with ctx, and
+// returns the name of the driver its results came from, whether that's the
+// one asked for or one it fell back to. Any trace already in ctx is still
+// called.
+func ServedBy(ctx context.Context, search func(ctx context.Context) error) (string, error) {
+	var served string
+
+	outer := traceFrom(ctx)
+	ctx = WithTrace(ctx, &Trace{
+		DriverFailed: outer.DriverFailed,
+		DriverServed: func(driverName string) {
+			served = driverName
+			if outer.DriverServed != nil {
+				outer.DriverServed(driverName)
+			}
+		},
+	})
+
+	if err := search(ctx); err != nil {
+		return "", fmt.Errorf("searchfiles.ServedBy: %w", err)
+	}
+
+	return served, nil
+}
+
+// SetFallbackOrder sets the drivers a search moves on to when a driver fails
+// with ErrExecution, in the order they're tried. If the failed driver is in
+// driverNames, only the ones after it are tried; otherwise they all are.
+// Drivers that aren't registered, or that can't do the kind of search being
+// made, are skipped. The default is not to fall back at all.
+func (s *Searcher) SetFallbackOrder(driverNames []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fallbackOrder = append([]string(nil), driverNames...)
+}
+
+func (s *Searcher) FallbackOrder() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.fallbackOrder...)
+}
+
+// fallbackChain returns the drivers to try, in order, for a search using
+// driverName.
+func (s *Searcher) fallbackChain(driverName string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if driverName == "" {
+		driverName = s.preferredDriver
+	}
+
+	chain := []string{driverName}
+
+	rest := s.fallbackOrder
+	for i, name := range s.fallbackOrder {
+		if name == driverName {
+			rest = s.fallbackOrder[i+1:]
+			break
+		}
+	}
+
+	for _, name := range rest {
+		if name != driverName {
+			chain = append(chain, name)
+		}
+	}
+
+	return chain
+}
+
+// fallback calls fn with each driver in the fallback chain for driverName,
+// until one of them works or fails with anything other than ErrExecution.
+// Once started is set, results have already been passed on, so retrying
+// would repeat them.
+func (s *Searcher) fallback(ctx context.Context, driverName string, started *bool, fn func(driverName string) error) error {
+	trace := traceFrom(ctx)
+
+	var errs []error
+
+	for i, name := range s.fallbackChain(driverName) {
+		err := fn(name)
+		if err == nil || errors.Is(err, ErrStop) {
+			if trace.DriverServed != nil {
+				trace.DriverServed(name)
+			}
+
+			return err
+		}
+
+		if i > 0 && (errors.Is(err, ErrUnknownDriver) || errors.Is(err, ErrUnimplemented)) {
+			continue
+		}
+
+		errs = append(errs, err)
+
+		if !errors.Is(err, ErrExecution) || (started != nil && *started) {
+			break
+		}
+
+		if trace.DriverFailed != nil {
+			trace.DriverFailed(name, err)
+		}
+	}
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+
+	return errors.Join(errs...)
+}
+
+// track wraps fn so that started is set once it's first called.
+func track[T any](fn func(T) error, started *bool) func(T) error {
+	return func(v T) error {
+		*started = true
+		return fn(v)
+	}
+}
@@ -0,0 +1,173 @@
+package searchfiles_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+type failingDriver struct {
+	namedDriver
+	err     error
+	results []string
+}
+
+func (d failingDriver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	return nil, d.err
+}
+
+func (d failingDriver) StreamLiteral(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	for _, file := range d.results {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+
+	return d.err
+}
+
+func (d failingDriver) StreamRegexp(ctx context.Context, directory, query string, fn searchfiles.StreamFunc) error {
+	return d.StreamLiteral(ctx, directory, query, fn)
+}
+
+func newFallbackSearcher() *searchfiles.Searcher {
+	s := searchfiles.NewSearcher()
+	s.Register("broken", failingDriver{namedDriver: "broken", err: fmt.Errorf("crashed: %w", searchfiles.ErrExecution)})
+	s.Register("invalid", failingDriver{namedDriver: "invalid", err: fmt.Errorf("bad query: %w", searchfiles.ErrInvalidQuery)})
+	s.Register("partial", failingDriver{namedDriver: "partial", err: fmt.Errorf("crashed: %w", searchfiles.ErrExecution), results: []string{"/a"}})
+	s.Register("streaming", failingDriver{namedDriver: "streaming", results: []string{"/b"}})
+	s.Register("working", namedDriver("working"))
+	s.SetPreferredDriver("broken")
+	return s
+}
+
+func TestFallback(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetFallbackOrder([]string{"broken", "missing", "working"})
+
+	var failed, served []string
+	ctx := searchfiles.WithTrace(context.Background(), &searchfiles.Trace{
+		DriverFailed: func(driverName string, err error) {
+			a.ErrorIs(err, searchfiles.ErrExecution)
+			failed = append(failed, driverName)
+		},
+		DriverServed: func(driverName string) {
+			served = append(served, driverName)
+		},
+	})
+
+	res, err := s.SearchLiteral(ctx, "/", "x")
+	a.NoError(err)
+	a.Equal([]string{"working"}, res)
+	a.Equal([]string{"broken"}, failed)
+	a.Equal([]string{"working"}, served)
+}
+
+func TestFallbackDisabled(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := newFallbackSearcher().SearchLiteral(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrExecution)
+}
+
+func TestFallbackAllFailed(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetFallbackOrder([]string{"partial"})
+
+	_, err := s.SearchLiteral(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrExecution)
+	a.Contains(err.Error(), "crashed")
+}
+
+func TestFallbackQueryError(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetFallbackOrder([]string{"invalid", "working"})
+
+	_, err := s.SearchLiteralUsing(context.Background(), "invalid", "/", "x")
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
+}
+
+func TestFallbackAfterUsedDriver(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetFallbackOrder([]string{"working", "broken", "invalid"})
+
+	_, err := s.SearchLiteral(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
+}
+
+func TestFallbackSkipsUnimplemented(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetFallbackOrder([]string{"working", "partial"})
+
+	var files []string
+	err := s.StreamLiteral(context.Background(), "/", "x", func(file string) error {
+		files = append(files, file)
+		return nil
+	})
+	a.ErrorIs(err, searchfiles.ErrExecution)
+	a.Equal([]string{"/a"}, files)
+}
+
+func TestFallbackStreamStarted(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetPreferredDriver("partial")
+	s.SetFallbackOrder([]string{"streaming"})
+
+	var files []string
+	err := s.StreamLiteral(context.Background(), "/", "x", func(file string) error {
+		files = append(files, file)
+		return nil
+	})
+	a.ErrorIs(err, searchfiles.ErrExecution)
+	a.Equal([]string{"/a"}, files)
+}
+
+func TestServedBy(t *testing.T) {
+	a := assert.New(t)
+
+	s := newFallbackSearcher()
+	s.SetFallbackOrder([]string{"working"})
+
+	var failed []string
+	ctx := searchfiles.WithTrace(context.Background(), &searchfiles.Trace{
+		DriverFailed: func(driverName string, err error) {
+			failed = append(failed, driverName)
+		},
+	})
+
+	var res []string
+	driverName, err := searchfiles.ServedBy(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = s.SearchLiteral(ctx, "/", "x")
+		return err
+	})
+	a.NoError(err)
+	a.Equal("working", driverName)
+	a.Equal([]string{"working"}, res)
+	a.Equal([]string{"broken"}, failed)
+
+	s.SetFallbackOrder(nil)
+
+	driverName, err = searchfiles.ServedBy(ctx, func(ctx context.Context) error {
+		_, err := s.SearchLiteral(ctx, "/", "x")
+		return err
+	})
+	a.ErrorIs(err, searchfiles.ErrExecution)
+	a.Equal("", driverName)
+}
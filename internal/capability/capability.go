@@ -0,0 +1,81 @@
+package capability
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/runctx"
+)
+
+var (
+	mu    sync.Mutex
+	cache = map[string]searchfiles.Capabilities{}
+)
+
+// Cached returns the capabilities of program, calling probe to find them out
+// the first time it's asked. Failed probes aren't remembered, so a program
+// that's installed later is picked up, and neither are probes of a program
+// that's since been replaced or found somewhere else on the PATH.
+func Cached(ctx context.Context, program string, probe func(ctx context.Context) (searchfiles.Capabilities, error)) (searchfiles.Capabilities, error) {
+	key := runctx.Identify(program)
+
+	mu.Lock()
+	c, ok := cache[key]
+	mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	c, err := probe(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("capability.Cached: %w", err)
+	}
+
+	mu.Lock()
+	cache[key] = c
+	mu.Unlock()
+
+	return c, nil
+}
+
+// Help returns base, along with each capability whose flags are all
+// mentioned in the output of program --help.
+func Help(ctx context.Context, program string, base searchfiles.Capabilities, flags map[searchfiles.Capabilities][]string) (searchfiles.Capabilities, error) {
+	lines, err := runctx.Run(ctx, program, []string{"--help"}, checkError)
+	if err != nil {
+		return 0, fmt.Errorf("capability.Help: %w", err)
+	}
+
+	help := strings.Join(lines, "\n")
+
+	c := base
+	for capability, names := range flags {
+		found := true
+		for _, name := range names {
+			if !strings.Contains(help, name) {
+				found = false
+				break
+			}
+		}
+		if found {
+			c |= capability
+		}
+	}
+
+	return c, nil
+}
+
+// Some programs exit unsuccessfully after printing their help, but what they
+// printed is just as good.
+func checkError(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() != -1 {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,56 @@
+package capability_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/capability"
+)
+
+func TestHelp(t *testing.T) {
+	a := assert.New(t)
+
+	// echo prints its arguments, which makes the help output "--help".
+	c, err := capability.Help(context.Background(), "echo", searchfiles.CapabilityRegexp, map[searchfiles.Capabilities][]string{
+		searchfiles.CapabilityMultiline: {"--help"},
+		searchfiles.CapabilityEncoding:  {"--help", "--xxx-not-a-flag"},
+	})
+	if a.NoError(err) {
+		a.Equal(searchfiles.CapabilityRegexp|searchfiles.CapabilityMultiline, c)
+	}
+}
+
+func TestHelpNotFound(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := capability.Help(context.Background(), "xxx-does-not-exist", 0, nil)
+	a.ErrorIs(err, searchfiles.ErrExecution)
+}
+
+func TestCached(t *testing.T) {
+	a := assert.New(t)
+
+	calls := 0
+	probe := func(ctx context.Context) (searchfiles.Capabilities, error) {
+		calls++
+		if calls == 1 {
+			return 0, fmt.Errorf("not yet")
+		}
+		return searchfiles.CapabilityBinary, nil
+	}
+
+	for i, expected := range []error{fmt.Errorf("capability.Cached: not yet"), nil, nil} {
+		c, err := capability.Cached(context.Background(), "test-cached", probe)
+		if expected != nil {
+			a.EqualError(err, expected.Error(), i)
+		} else if a.NoError(err, i) {
+			a.Equal(searchfiles.CapabilityBinary, c, i)
+		}
+	}
+
+	a.Equal(2, calls)
+}
@@ -0,0 +1,115 @@
+package glob
+
+import (
+	"fmt"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// Filter applies the Include, Exclude and Types options of a search to
+// paths. A nil *Filter doesn't skip anything.
+type Filter struct {
+	include Set
+	exclude Set
+	types   Set
+}
+
+// NewFilter returns nil if options don't restrict which files are searched.
+func NewFilter(options searchfiles.SearchOptions) (*Filter, error) {
+	if len(options.Include) == 0 && len(options.Exclude) == 0 && len(options.Types) == 0 {
+		return nil, nil
+	}
+
+	var f Filter
+	var err error
+
+	if f.include, err = CompileSet(options.Include); err != nil {
+		return nil, fmt.Errorf("glob.NewFilter: could not compile include globs: %w", err)
+	}
+
+	if f.exclude, err = CompileSet(options.Exclude); err != nil {
+		return nil, fmt.Errorf("glob.NewFilter: could not compile exclude globs: %w", err)
+	}
+
+	typeGlobs, err := TypeGlobs(options.Types)
+	if err != nil {
+		return nil, fmt.Errorf("glob.NewFilter: %w", err)
+	}
+
+	if f.types, err = CompileSet(typeGlobs); err != nil {
+		return nil, fmt.Errorf("glob.NewFilter: could not compile type globs: %w", err)
+	}
+
+	return &f, nil
+}
+
+// TypeGlobs returns the globs for each of the named file types.
+func TypeGlobs(types []string) ([]string, error) {
+	var globs []string
+
+	for _, e := range types {
+		a, ok := searchfiles.FileTypes[e]
+		if !ok {
+			return nil, fmt.Errorf("glob.TypeGlobs: %q: %w", e, searchfiles.ErrUnknownFileType)
+		}
+
+		globs = append(globs, a...)
+	}
+
+	return globs, nil
+}
+
+// SkipDir reports whether the directory at path, and everything in it,
+// should be skipped.
+func (f *Filter) SkipDir(path string) bool {
+	if f == nil {
+		return false
+	}
+
+	return f.exclude.Match(path, true)
+}
+
+// SkipFile reports whether the file at path should be skipped. It doesn't
+// look at the directories the file is in; see SkipPath for that.
+func (f *Filter) SkipFile(path string) bool {
+	if f == nil {
+		return false
+	}
+
+	if f.exclude.Match(path, false) {
+		return true
+	}
+
+	if len(f.include) > 0 && !f.include.Match(path, false) {
+		return true
+	}
+
+	if len(f.types) > 0 && !f.types.Match(path, false) {
+		return true
+	}
+
+	return false
+}
+
+// SkipPath is like SkipFile, but also skips the file if any of the
+// directories leading to it would be skipped.
+func (f *Filter) SkipPath(path string) bool {
+	if f == nil {
+		return false
+	}
+
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' && f.SkipDir(path[:i]) {
+			return true
+		}
+	}
+
+	return f.SkipFile(path)
+}
+
+// Relative turns a result path like "/subdir/file.txt" into the form used
+// for matching, like "subdir/file.txt".
+func Relative(result string) string {
+	return strings.TrimPrefix(result, "/")
+}
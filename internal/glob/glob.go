@@ -0,0 +1,198 @@
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pattern is a compiled glob, following the same rules as gitignore:
+//
+//   - a glob with no slash in it (other than a trailing one) matches the name
+//     of a file or directory at any depth
+//   - otherwise it's matched against the whole path, relative to the root
+//   - "*" and "?" don't match "/", but "**" matches any number of directories
+//   - a trailing slash only matches directories
+type Pattern struct {
+	glob    string
+	re      *regexp.Regexp
+	prefix  *regexp.Regexp
+	dirOnly bool
+}
+
+func Compile(glob string) (*Pattern, error) {
+	p := &Pattern{glob: glob}
+
+	s := glob
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimSuffix(s, "/")
+	}
+	if s == "" {
+		return nil, fmt.Errorf("glob.Compile: empty glob %q", glob)
+	}
+
+	expr, err := Expression(s)
+	if err != nil {
+		return nil, fmt.Errorf("glob.Compile: %w", err)
+	}
+
+	if p.re, err = regexp.Compile(expr); err != nil {
+		return nil, fmt.Errorf("glob.Compile: could not compile %q: %w", glob, err)
+	}
+
+	// "dir/**" matches everything inside dir, but not dir itself. Matching it
+	// as well lets callers skip the directory entirely.
+	if strings.HasSuffix(s, "/**") {
+		expr, err := Expression(strings.TrimSuffix(s, "/**"))
+		if err != nil {
+			return nil, fmt.Errorf("glob.Compile: %w", err)
+		}
+
+		if p.prefix, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("glob.Compile: could not compile %q: %w", glob, err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Pattern) String() string {
+	return p.glob
+}
+
+// Match reports whether path, which is slash separated and relative to the
+// root, matches the glob.
+func (p *Pattern) Match(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if p.re.MatchString(path) {
+		return true
+	}
+
+	return isDir && p.prefix != nil && p.prefix.MatchString(path)
+}
+
+// IsBase reports whether the glob only ever looks at the last element of a
+// path, as with "*.go" or "vendor/".
+func IsBase(glob string) bool {
+	return !strings.Contains(strings.TrimSuffix(glob, "/"), "/")
+}
+
+// Expression translates a glob (without any trailing slash) into an anchored
+// Go regular expression.
+func Expression(glob string) (string, error) {
+	var b strings.Builder
+
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+		b.WriteString(`^`)
+	} else {
+		b.WriteString(`^(?:.*/)?`)
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if !strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(`[^/]*`)
+				continue
+			}
+
+			atStart := i == 0 || glob[i-1] == '/'
+			switch {
+			case atStart && strings.HasPrefix(glob[i:], "**/"):
+				b.WriteString(`(?:.*/)?`)
+				i += 2
+			case atStart && i+2 == len(glob):
+				b.WriteString(`.*`)
+				i++
+			default:
+				b.WriteString(`[^/]*`)
+				i++
+			}
+		case '?':
+			b.WriteString(`[^/]`)
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				return "", fmt.Errorf("glob.Expression: unterminated character class in %q", glob)
+			}
+
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString(`$`)
+
+	return b.String(), nil
+}
+
+// Set is a list of globs that matches a path if any of its globs do.
+type Set []*Pattern
+
+func CompileSet(globs []string) (Set, error) {
+	var s Set
+
+	for _, e := range globs {
+		p, err := Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("glob.CompileSet: %w", err)
+		}
+
+		s = append(s, p)
+	}
+
+	return s, nil
+}
+
+func (s Set) Match(path string, isDir bool) bool {
+	for _, p := range s {
+		if p.Match(path, isDir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NameExpression returns a regular expression that matches the paths of
+// files whose names match any of globs, for tools that filter files by
+// regexp. It returns "" if any of the globs look at more than the name.
+func NameExpression(globs []string) (string, error) {
+	var parts []string
+
+	for _, e := range globs {
+		if !IsBase(e) || strings.HasSuffix(e, "/") {
+			return "", nil
+		}
+
+		expr, err := Expression(e)
+		if err != nil {
+			return "", fmt.Errorf("glob.NameExpression: %w", err)
+		}
+
+		parts = append(parts, strings.TrimSuffix(strings.TrimPrefix(expr, `^(?:.*/)?`), `$`))
+	}
+
+	if len(parts) == 0 {
+		return "", nil
+	}
+
+	return `(?:^|/)(?:` + strings.Join(parts, `|`) + `)$`, nil
+}
@@ -0,0 +1,80 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles/internal/glob"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		glob  string
+		path  string
+		isDir bool
+		match bool
+	}{
+		{glob: "*.go", path: "main.go", match: true},
+		{glob: "*.go", path: "a/b/main.go", match: true},
+		{glob: "*.go", path: "main.go/x", match: false},
+		{glob: "vendor", path: "a/vendor", isDir: true, match: true},
+		{glob: "vendor/", path: "a/vendor", isDir: false, match: false},
+		{glob: "vendor/", path: "a/vendor", isDir: true, match: true},
+		{glob: "vendor/**", path: "vendor/x/y.go", match: true},
+		{glob: "vendor/**", path: "vendor", isDir: true, match: true},
+		{glob: "vendor/**", path: "a/vendor/x/y.go", match: false},
+		{glob: "/vendor/*.go", path: "vendor/y.go", match: true},
+		{glob: "src/*.go", path: "src/a/y.go", match: false},
+		{glob: "**/testdata/*.txt", path: "testdata/a.txt", match: true},
+		{glob: "**/testdata/*.txt", path: "a/b/testdata/a.txt", match: true},
+		{glob: "a/**/b.txt", path: "a/b.txt", match: true},
+		{glob: "a/**/b.txt", path: "a/x/y/b.txt", match: true},
+		{glob: "file?.txt", path: "file1.txt", match: true},
+		{glob: "file?.txt", path: "file10.txt", match: false},
+		{glob: "file[12].txt", path: "file2.txt", match: true},
+		{glob: "file[!12].txt", path: "file2.txt", match: false},
+		{glob: "file[!12].txt", path: "file3.txt", match: true},
+		{glob: `\*.txt`, path: "*.txt", match: true},
+		{glob: `\*.txt`, path: "a.txt", match: false},
+		{glob: "a+b(c).txt", path: "a+b(c).txt", match: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			a := assert.New(t)
+
+			p, err := glob.Compile(tt.glob)
+			a.NoError(err)
+			a.Equal(tt.match, p.Match(tt.path, tt.isDir))
+		})
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	_, err := glob.Compile("file[12.txt")
+	a.Error(err)
+
+	_, err = glob.Compile("")
+	a.Error(err)
+}
+
+func TestNameExpression(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	expr, err := glob.NameExpression([]string{"*.go", "a?.txt"})
+	a.NoError(err)
+	a.Equal(`(?:^|/)(?:[^/]*\.go|a[^/]\.txt)$`, expr)
+
+	expr, err = glob.NameExpression([]string{"*.go", "src/*.go"})
+	a.NoError(err)
+	a.Equal("", expr)
+}
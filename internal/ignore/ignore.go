@@ -0,0 +1,217 @@
+package ignore
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/glob"
+)
+
+const (
+	VCSIgnoreFile = ".gitignore"
+	IgnoreFile    = ".ignore"
+)
+
+// Matcher decides which paths under a root directory are skipped by an
+// IgnorePolicy. Ignore files are read lazily, the first time a path in their
+// directory is looked at, and follow gitignore rules: the last matching
+// pattern in a file wins, "!" re-includes a path, files in deeper
+// directories take precedence over those above them, and .ignore takes
+// precedence over .gitignore. A nil *Matcher doesn't skip anything.
+type Matcher struct {
+	fsys   fs.FS
+	files  []string
+	hidden bool
+
+	mu    sync.Mutex
+	rules map[string][]rule
+}
+
+type rule struct {
+	pattern *glob.Pattern
+	negate  bool
+}
+
+// New returns a Matcher for the files under root, or nil if policy doesn't
+// skip anything.
+func New(root string, policy searchfiles.IgnorePolicy) *Matcher {
+	return NewFS(os.DirFS(root), policy)
+}
+
+// NewFS is like New, but for the files in fsys.
+func NewFS(fsys fs.FS, policy searchfiles.IgnorePolicy) *Matcher {
+	var files []string
+
+	// Lowest precedence first, as later rules win.
+	if !policy.NoVCSIgnore {
+		files = append(files, VCSIgnoreFile)
+	}
+	if !policy.NoIgnoreFiles {
+		files = append(files, IgnoreFile)
+	}
+
+	if len(files) == 0 && policy.Hidden {
+		return nil
+	}
+
+	return &Matcher{
+		fsys:   fsys,
+		files:  files,
+		hidden: policy.Hidden,
+		rules:  make(map[string][]rule),
+	}
+}
+
+// Ignored reports whether the file or directory at name, which is slash
+// separated and relative to the root, should be skipped. It assumes the
+// directories leading to it aren't; see SkipPath for that.
+func (m *Matcher) Ignored(name string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	if !m.hidden && strings.HasPrefix(path.Base(name), ".") {
+		return true
+	}
+
+	for dir := parentDir(name); ; dir = parentDir(dir) {
+		rel := name
+		if dir != "" {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+
+		rules := m.load(dir)
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].pattern.Match(rel, isDir) {
+				return !rules[i].negate
+			}
+		}
+
+		if dir == "" {
+			return false
+		}
+	}
+}
+
+// SkipPath reports whether the file at name should be skipped, either
+// because it's ignored itself or because one of the directories leading to
+// it is.
+func (m *Matcher) SkipPath(name string) bool {
+	if m == nil {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' && m.Ignored(name[:i], true) {
+			return true
+		}
+	}
+
+	return m.Ignored(name, false)
+}
+
+// DirGlobs returns the globs in the ignore files at the root that ignore
+// directories by name alone, like "node_modules/", so that tools can skip
+// those directories rather than walk them only for the results to be thrown
+// away. Globs that a later rule negates are left out, since the negation
+// might bring a directory back, but negations in ignore files further down
+// aren't looked for.
+func (m *Matcher) DirGlobs() []string {
+	if m == nil {
+		return nil
+	}
+
+	rules := m.load("")
+
+	var globs []string
+
+outer:
+	for i, r := range rules {
+		glob := strings.TrimSuffix(r.pattern.String(), "/")
+		if r.negate || strings.Contains(glob, "/") || strings.Contains(glob, `\`) || strings.Contains(glob, "**") {
+			continue
+		}
+
+		for _, later := range rules[i+1:] {
+			if later.negate {
+				continue outer
+			}
+		}
+
+		globs = append(globs, glob)
+	}
+
+	return globs
+}
+
+func (m *Matcher) load(dir string) []rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rules, ok := m.rules[dir]; ok {
+		return rules
+	}
+
+	var rules []rule
+
+	for _, e := range m.files {
+		// Ignore files that can't be read are skipped, as rg and friends do.
+		data, err := fs.ReadFile(m.fsys, path.Join(dir, e))
+		if err != nil {
+			continue
+		}
+
+		rules = append(rules, parse(string(data))...)
+	}
+
+	m.rules[dir] = rules
+
+	return rules
+}
+
+// parse reads the rules from the contents of an ignore file. Lines that
+// can't be parsed are skipped.
+func parse(data string) []rule {
+	var rules []rule
+
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Trailing spaces are dropped unless they're escaped.
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimSuffix(line, " ")
+		}
+
+		var r rule
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		}
+
+		p, err := glob.Compile(line)
+		if err != nil {
+			continue
+		}
+		r.pattern = p
+
+		rules = append(rules, r)
+	}
+
+	return rules
+}
+
+func parentDir(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i != -1 {
+		return name[:i]
+	}
+
+	return ""
+}
@@ -0,0 +1,105 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+func TestMatcher(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":   "# comment\n*.log\n!keep.log\n/build/\ntmp/\ntrailing   \n",
+		".ignore":      "secret.txt\n!*.log\n",
+		"a/.gitignore": "*.txt\n!/b/keep.txt\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		policy  searchfiles.IgnorePolicy
+		name    string
+		isDir   bool
+		ignored bool
+	}{
+		{name: "plain.txt"},
+		{name: "app.md.log", ignored: false},
+		{name: "keep.log"},
+		{name: "build", isDir: true, ignored: true},
+		{name: "a/build", isDir: true},
+		{name: "tmp", isDir: false},
+		{name: "a/tmp", isDir: true, ignored: true},
+		{name: "trailing", ignored: true},
+		{name: "secret.txt", ignored: true},
+		{name: "a/secret.txt", ignored: true},
+		{name: "a/plain.txt", ignored: true},
+		{name: "a/b/keep.txt"},
+		{name: "a/b/other.txt", ignored: true},
+		{name: ".hidden", ignored: true},
+		{name: "a/.hidden", isDir: true, ignored: true},
+		{policy: searchfiles.IgnorePolicy{Hidden: true}, name: ".hidden"},
+		{policy: searchfiles.IgnorePolicy{NoVCSIgnore: true}, name: "a/plain.txt"},
+		{policy: searchfiles.IgnorePolicy{NoVCSIgnore: true}, name: "secret.txt", ignored: true},
+		{policy: searchfiles.IgnorePolicy{NoIgnoreFiles: true}, name: "secret.txt"},
+		{policy: searchfiles.IgnorePolicy{NoIgnoreFiles: true}, name: "app.log", ignored: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := assert.New(t)
+			a.Equal(tt.ignored, New(root, tt.policy).Ignored(tt.name, tt.isDir))
+		})
+	}
+}
+
+func TestMatcherSkipPath(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".gitignore"), []byte("vendor/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(root, searchfiles.IgnorePolicy{})
+	a.True(m.SkipPath("a/vendor/b/c.go"))
+	a.True(m.SkipPath(".git/config"))
+	a.False(m.SkipPath("a/b/c.go"))
+	a.Nil(New(root, searchfiles.SearchEverything))
+}
+
+func TestDirGlobs(t *testing.T) {
+	a := assert.New(t)
+
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":   "*.tmp\n!keep.tmp\nnode_modules/\n/build/\ndist\nlogs/**\n",
+		".ignore":      "vendor/\n",
+		"a/.gitignore": "cache/\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// *.tmp is left out, as it's followed by a negation, and so are the
+	// globs that look at more than a name.
+	a.Equal([]string{"node_modules", "dist", "vendor"}, New(root, searchfiles.IgnorePolicy{}).DirGlobs())
+	a.Equal([]string{"vendor"}, New(root, searchfiles.IgnorePolicy{NoVCSIgnore: true}).DirGlobs())
+	a.Empty(New(root, searchfiles.IgnorePolicy{NoIgnoreFiles: true, NoVCSIgnore: true, Hidden: true}).DirGlobs())
+}
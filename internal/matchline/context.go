@@ -0,0 +1,128 @@
+package matchline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// ContextBuilder puts together ContextMatches from the lines of one or more
+// files, given in order. Only lines within range of a match need to be
+// passed in, which is all that tools print with -B and -A, but passing
+// others is harmless.
+type ContextBuilder struct {
+	before  int
+	after   int
+	path    string
+	pending []searchfiles.ContextLine
+	matches []searchfiles.ContextMatch
+}
+
+func NewContextBuilder(before, after int) *ContextBuilder {
+	return &ContextBuilder{before: before, after: after}
+}
+
+// Match adds a matching line, taking any context lines that precede it.
+func (b *ContextBuilder) Match(m searchfiles.Match) {
+	b.switchPath(m.Path)
+
+	cm := searchfiles.ContextMatch{Match: m}
+	for _, l := range b.pending {
+		if l.LineNumber < m.LineNumber && l.LineNumber >= m.LineNumber-b.before {
+			cm.Before = append(cm.Before, l)
+		}
+	}
+	b.pending = b.pending[:0]
+
+	b.matches = append(b.matches, cm)
+}
+
+// Context adds a line that didn't match. It goes to the previous match if
+// it's close enough after it, and is otherwise held for the next one.
+func (b *ContextBuilder) Context(path string, lineNumber int, line string) {
+	b.switchPath(path)
+
+	l := searchfiles.ContextLine{LineNumber: lineNumber, Line: line}
+
+	if n := len(b.matches); n > 0 {
+		// A multiline match covers more than one line, and what comes after
+		// it is counted from the last of them.
+		last := &b.matches[n-1]
+		end := last.LineNumber + strings.Count(last.Line, "\n")
+		if last.Path == path && lineNumber > end && lineNumber <= end+b.after {
+			last.After = append(last.After, l)
+			return
+		}
+	}
+
+	if b.before == 0 {
+		return
+	}
+
+	if len(b.pending) == b.before {
+		b.pending = append(b.pending[:0], b.pending[1:]...)
+	}
+	b.pending = append(b.pending, l)
+}
+
+func (b *ContextBuilder) switchPath(path string) {
+	if path != b.path {
+		b.path = path
+		b.pending = b.pending[:0]
+	}
+}
+
+// Matches returns everything that's been added so far.
+func (b *ContextBuilder) Matches() []searchfiles.ContextMatch {
+	return b.matches
+}
+
+// ParseContext is like Parse, but for output that includes context lines, as
+// printed by grep -A and -B. Those have a '-' after the line number instead
+// of a ':', and they're reported by the second return value. Separators
+// between groups of lines should be dropped before they get here.
+func ParseContext(directory, output string, withOffset bool, re *regexp.Regexp) (searchfiles.Match, bool, error) {
+	path, rest, ok := strings.Cut(output, "\x00")
+	if !ok {
+		return searchfiles.Match{}, false, fmt.Errorf("matchline.ParseContext: could not find file name in %q", output)
+	}
+
+	i := strings.IndexAny(rest, ":-")
+	if i == -1 {
+		return searchfiles.Match{}, false, fmt.Errorf("matchline.ParseContext: could not find line number in %q", output)
+	}
+
+	isContext := rest[i] == '-'
+
+	lineNumber, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return searchfiles.Match{}, false, fmt.Errorf("matchline.ParseContext: could not parse line number in %q: %w", output, err)
+	}
+	rest = rest[i+1:]
+
+	offset := int64(-1)
+	if withOffset {
+		sep := ":"
+		if isContext {
+			sep = "-"
+		}
+
+		var offsetText string
+		if offsetText, rest, ok = strings.Cut(rest, sep); !ok {
+			return searchfiles.Match{}, false, fmt.Errorf("matchline.ParseContext: could not find byte offset in %q", output)
+		}
+
+		if offset, err = strconv.ParseInt(offsetText, 10, 64); err != nil {
+			return searchfiles.Match{}, false, fmt.Errorf("matchline.ParseContext: could not parse byte offset in %q: %w", output, err)
+		}
+	}
+
+	if isContext {
+		re = nil
+	}
+
+	return New(strings.TrimPrefix(path, directory), lineNumber, offset, rest, re), isContext, nil
+}
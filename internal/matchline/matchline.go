@@ -0,0 +1,132 @@
+package matchline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// New builds a Match for a single line, using re (if it's not nil) to find
+// the submatches within it.
+func New(path string, lineNumber int, offset int64, line string, re *regexp.Regexp) searchfiles.Match {
+	m := searchfiles.Match{
+		Path:       path,
+		LineNumber: lineNumber,
+		Offset:     offset,
+		Line:       line,
+	}
+
+	if re == nil {
+		return m
+	}
+
+	for _, loc := range re.FindAllStringIndex(line, -1) {
+		m.Submatches = append(m.Submatches, searchfiles.Submatch{
+			Start: loc[0],
+			End:   loc[1],
+			Text:  line[loc[0]:loc[1]],
+		})
+	}
+
+	if len(m.Submatches) > 0 {
+		m.Column = m.Submatches[0].Start + 1
+	}
+
+	return m
+}
+
+// Parse parses one line of grep-style output. The file name is expected to be
+// followed by a NUL byte (as with grep's --null), then the line number, the
+// byte offset of the line if withOffset is true, and finally the line itself,
+// all separated by colons. Some tools don't emit the NUL in every mode, in
+// which case the file name is assumed to end at the first colon.
+func Parse(directory, output string, withOffset bool, re *regexp.Regexp) (searchfiles.Match, error) {
+	path, rest, ok := strings.Cut(output, "\x00")
+	if !ok {
+		if path, rest, ok = strings.Cut(output, ":"); !ok {
+			return searchfiles.Match{}, fmt.Errorf("matchline.Parse: could not find file name in %q", output)
+		}
+	}
+
+	lineNumberText, rest, ok := strings.Cut(rest, ":")
+	if !ok {
+		return searchfiles.Match{}, fmt.Errorf("matchline.Parse: could not find line number in %q", output)
+	}
+
+	lineNumber, err := strconv.Atoi(lineNumberText)
+	if err != nil {
+		return searchfiles.Match{}, fmt.Errorf("matchline.Parse: could not parse line number in %q: %w", output, err)
+	}
+
+	offset := int64(-1)
+	if withOffset {
+		var offsetText string
+		if offsetText, rest, ok = strings.Cut(rest, ":"); !ok {
+			return searchfiles.Match{}, fmt.Errorf("matchline.Parse: could not find byte offset in %q", output)
+		}
+
+		if offset, err = strconv.ParseInt(offsetText, 10, 64); err != nil {
+			return searchfiles.Match{}, fmt.Errorf("matchline.Parse: could not parse byte offset in %q: %w", output, err)
+		}
+	}
+
+	return New(strings.TrimPrefix(path, directory), lineNumber, offset, rest, re), nil
+}
+
+// ParseAll is like Parse, but for many lines of output at once.
+func ParseAll(directory string, output []string, withOffset bool, re *regexp.Regexp) ([]searchfiles.Match, error) {
+	var matches []searchfiles.Match
+
+	for _, e := range output {
+		m, err := Parse(directory, e, withOffset, re)
+		if err != nil {
+			return nil, fmt.Errorf("matchline.ParseAll: %w", err)
+		}
+
+		matches = append(matches, m)
+	}
+
+	return matches, nil
+}
+
+// ParseCount parses one line of the output of grep -c and similar, which is
+// a file name followed by a NUL byte or a colon, then the number of matches.
+// Without the NUL, the file name is assumed to end at the last colon.
+func ParseCount(directory, output string) (string, int, error) {
+	path, countText, ok := strings.Cut(output, "\x00")
+	if !ok {
+		i := strings.LastIndex(output, ":")
+		if i == -1 {
+			return "", 0, fmt.Errorf("matchline.ParseCount: could not find file name in %q", output)
+		}
+
+		path, countText = output[:i], output[i+1:]
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(countText))
+	if err != nil {
+		return "", 0, fmt.Errorf("matchline.ParseCount: could not parse count in %q: %w", output, err)
+	}
+
+	return strings.TrimPrefix(path, directory), count, nil
+}
+
+// Count adds up matches per file, for tools that can't count for themselves.
+// With occurrences, each line counts once for every submatch in it, or once
+// if its submatches weren't found.
+func Count(matches []searchfiles.Match, occurrences bool) searchfiles.Counts {
+	counts := searchfiles.Counts{}
+
+	for _, m := range matches {
+		if n := len(m.Submatches); occurrences && n > 0 {
+			counts[m.Path] += n
+		} else {
+			counts[m.Path]++
+		}
+	}
+
+	return counts
+}
@@ -0,0 +1,130 @@
+package multi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
+)
+
+// Set is a compiled set of patterns for a multi-pattern search.
+type Set struct {
+	res []*regexp.Regexp
+}
+
+// Compile compiles each of the queries under options, with SmartCase
+// resolved for all of them together. Patterns are matched line by line, so
+// they can't contain line breaks.
+func Compile(queries []string, options searchfiles.SearchOptions) (*Set, error) {
+	options = pattern.ResolveCase(queries, options)
+
+	s := &Set{res: make([]*regexp.Regexp, len(queries))}
+
+	for i, query := range queries {
+		if strings.Contains(query, "\n") {
+			return nil, fmt.Errorf("multi.Compile: pattern %d contains a line break", i)
+		}
+
+		re, err := pattern.Compile(query, options)
+		if err != nil {
+			return nil, fmt.Errorf("multi.Compile: pattern %d: %w", i, err)
+		}
+
+		s.res[i] = re
+	}
+
+	return s, nil
+}
+
+// Len returns the number of patterns in the set.
+func (s *Set) Len() int {
+	return len(s.res)
+}
+
+// Match marks the patterns that match line in found, and returns how many of
+// them weren't marked already. Patterns that are already marked aren't
+// checked again.
+func (s *Set) Match(line string, found []bool) int {
+	n := 0
+
+	for i, re := range s.res {
+		if !found[i] && re.MatchString(line) {
+			found[i] = true
+			n++
+		}
+	}
+
+	return n
+}
+
+// Result turns the patterns found in a file into a MultiResult. It reports
+// false if the file doesn't qualify under mode.
+func Result(path string, found []bool, mode searchfiles.MultiMode) (searchfiles.MultiResult, bool) {
+	var patterns []int
+	for i, ok := range found {
+		if ok {
+			patterns = append(patterns, i)
+		}
+	}
+
+	switch {
+	case len(patterns) == 0:
+		return searchfiles.MultiResult{}, false
+	case mode == searchfiles.AllOf && len(patterns) != len(found):
+		return searchfiles.MultiResult{}, false
+	}
+
+	return searchfiles.MultiResult{Path: path, Patterns: patterns}, true
+}
+
+// Collector works out which patterns matched each file from a stream of
+// matching lines, as printed by tools that can search for several patterns
+// at once but can't say which of them matched. Lines from the same file have
+// to arrive together.
+type Collector struct {
+	set   *Set
+	mode  searchfiles.MultiMode
+	fn    searchfiles.MultiFunc
+	path  string
+	found []bool
+}
+
+func NewCollector(set *Set, mode searchfiles.MultiMode, fn searchfiles.MultiFunc) *Collector {
+	return &Collector{set: set, mode: mode, fn: fn}
+}
+
+// Line records a matching line from path. If it's the first line from a new
+// file, the previous file is passed on to fn.
+func (c *Collector) Line(path, line string) error {
+	if path != c.path || c.found == nil {
+		if err := c.Flush(); err != nil {
+			return err
+		}
+
+		c.path = path
+		c.found = make([]bool, c.set.Len())
+	}
+
+	c.set.Match(line, c.found)
+
+	return nil
+}
+
+// Flush passes the current file on to fn, if it qualifies. It has to be
+// called once the last line has been recorded.
+func (c *Collector) Flush() error {
+	if c.found == nil {
+		return nil
+	}
+
+	found := c.found
+	c.found = nil
+
+	if r, ok := Result(c.path, found, c.mode); ok {
+		return c.fn(r)
+	}
+
+	return nil
+}
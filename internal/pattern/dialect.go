@@ -0,0 +1,295 @@
+package pattern
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// Dialect is the regexp syntax of a search tool.
+type Dialect int
+
+const (
+	// RE2 is the syntax of Go's regexp package, and of queries.
+	RE2 Dialect = iota
+	// PCRE is the syntax of grep --perl-regexp and ag.
+	PCRE
+	// Rust is the syntax of rg's default regexp engine.
+	Rust
+)
+
+// Parse parses query as an RE2 regexp, with ^ and $ matching at the start and
+// end of each line, as they do everywhere queries are matched. Errors wrap
+// searchfiles.ErrInvalidQuery.
+func Parse(query string) (*syntax.Regexp, error) {
+	re, err := syntax.Parse(query, syntax.Perl&^syntax.OneLine)
+	if err != nil {
+		return nil, fmt.Errorf("pattern.Parse: %w: %w", searchfiles.ErrInvalidQuery, err)
+	}
+
+	return re, nil
+}
+
+// Translate rewrites the RE2 regexp query in the given dialect, so that it
+// matches the same things there as it does in Go. Anything that's written
+// differently from one dialect to the next, like character classes, word
+// boundaries and flags, is spelled out in a form they all agree on. PCRE
+// translations of queries that NeedsUTF only work in UTFMode.
+func Translate(query string, dialect Dialect) (string, error) {
+	re, err := Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("pattern.Translate: %w", err)
+	}
+
+	var b strings.Builder
+	writeRegexp(&b, re, dialect)
+
+	return b.String(), nil
+}
+
+// UTFMode starts a PCRE pattern to make it match by character rather than by
+// byte, as RE2 does. It has to come before anything else in the pattern.
+const UTFMode = "(*UTF)"
+
+// NeedsUTF reports whether query, translated to PCRE, has to be run in
+// UTFMode to match what it does in RE2: if it spells out characters beyond
+// ASCII, or could match one with . or a class. UTF mode is left off where
+// it makes no difference, as PCRE fails on files that aren't valid UTF-8 in
+// it unless the tool running it has been set up for them, which grep only is
+// in a UTF-8 locale. Queries that don't compile are reported as not needing
+// it.
+func NeedsUTF(query string) bool {
+	re, err := Parse(query)
+	if err != nil {
+		return false
+	}
+
+	return needsUTF(re)
+}
+
+func needsUTF(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r >= utf8RuneSelf {
+				return true
+			}
+		}
+	case syntax.OpCharClass:
+		// The ranges are sorted, so the last one reaches the furthest.
+		if len(re.Rune) > 0 && re.Rune[len(re.Rune)-1] >= utf8RuneSelf {
+			return true
+		}
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	}
+
+	for _, sub := range re.Sub {
+		if needsUTF(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// asciiWord is what RE2 counts as a word character for \b and \B. rg's \b
+// counts any Unicode letter, and PCRE's can too, depending on how it's built
+// and the locale.
+const asciiWord = `[0-9A-Z_a-z]`
+
+func writeRegexp(b *strings.Builder, re *syntax.Regexp, dialect Dialect) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		writeNoMatch(b, dialect)
+	case syntax.OpEmptyMatch:
+		b.WriteString(`(?:)`)
+	case syntax.OpLiteral:
+		fold := re.Flags&syntax.FoldCase != 0
+		if fold {
+			b.WriteString(`(?i:`)
+		}
+		for _, r := range re.Rune {
+			writeRune(b, r, dialect, false)
+		}
+		if fold {
+			b.WriteString(`)`)
+		}
+	case syntax.OpCharClass:
+		writeClass(b, re.Rune, dialect)
+	case syntax.OpAnyCharNotNL:
+		b.WriteString(`[^\n]`)
+	case syntax.OpAnyChar:
+		b.WriteString(`(?s:.)`)
+	case syntax.OpBeginLine:
+		b.WriteString(`(?m:^)`)
+	case syntax.OpEndLine:
+		b.WriteString(`(?m:$)`)
+	case syntax.OpBeginText:
+		b.WriteString(`\A`)
+	case syntax.OpEndText:
+		b.WriteString(`\z`)
+	case syntax.OpWordBoundary:
+		switch dialect {
+		case PCRE:
+			b.WriteString(`(?:(?<=` + asciiWord + `)(?!` + asciiWord + `)|(?<!` + asciiWord + `)(?=` + asciiWord + `))`)
+		case Rust:
+			b.WriteString(`(?-u:\b)`)
+		default:
+			b.WriteString(`\b`)
+		}
+	case syntax.OpNoWordBoundary:
+		switch dialect {
+		case PCRE:
+			b.WriteString(`(?:(?<=` + asciiWord + `)(?=` + asciiWord + `)|(?<!` + asciiWord + `)(?!` + asciiWord + `))`)
+		case Rust:
+			b.WriteString(`(?-u:\B)`)
+		default:
+			b.WriteString(`\B`)
+		}
+	case syntax.OpCapture:
+		// Names aren't needed to match, and not every dialect writes them
+		// the same way.
+		b.WriteString(`(`)
+		writeRegexp(b, re.Sub[0], dialect)
+		b.WriteString(`)`)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		writeAtom(b, re.Sub[0], dialect)
+		switch re.Op {
+		case syntax.OpStar:
+			b.WriteString(`*`)
+		case syntax.OpPlus:
+			b.WriteString(`+`)
+		case syntax.OpQuest:
+			b.WriteString(`?`)
+		default:
+			switch {
+			case re.Max == -1:
+				fmt.Fprintf(b, `{%d,}`, re.Min)
+			case re.Min == re.Max:
+				fmt.Fprintf(b, `{%d}`, re.Min)
+			default:
+				fmt.Fprintf(b, `{%d,%d}`, re.Min, re.Max)
+			}
+		}
+		if re.Flags&syntax.NonGreedy != 0 {
+			b.WriteString(`?`)
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpAlternate {
+				writeGroup(b, sub, dialect)
+			} else {
+				writeRegexp(b, sub, dialect)
+			}
+		}
+	case syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			if i > 0 {
+				b.WriteString(`|`)
+			}
+			writeRegexp(b, sub, dialect)
+		}
+	}
+}
+
+// writeAtom writes re so that it can be repeated as a whole.
+func writeAtom(b *strings.Builder, re *syntax.Regexp, dialect Dialect) {
+	switch {
+	case re.Op == syntax.OpLiteral && len(re.Rune) == 1,
+		re.Op == syntax.OpCharClass,
+		re.Op == syntax.OpAnyChar,
+		re.Op == syntax.OpAnyCharNotNL,
+		re.Op == syntax.OpCapture:
+		writeRegexp(b, re, dialect)
+	default:
+		writeGroup(b, re, dialect)
+	}
+}
+
+func writeGroup(b *strings.Builder, re *syntax.Regexp, dialect Dialect) {
+	b.WriteString(`(?:`)
+	writeRegexp(b, re, dialect)
+	b.WriteString(`)`)
+}
+
+func writeNoMatch(b *strings.Builder, dialect Dialect) {
+	if dialect == PCRE {
+		b.WriteString(`(?!)`)
+	} else {
+		b.WriteString(`[^\x00-\x{10FFFF}]`)
+	}
+}
+
+// writeClass writes the character class made of the pairs of ranges in
+// runes. Classes that run up to the last code point are written negated, as
+// they were most likely written that way, and it keeps them short.
+func writeClass(b *strings.Builder, runes []rune, dialect Dialect) {
+	if len(runes) == 0 {
+		writeNoMatch(b, dialect)
+		return
+	}
+	if len(runes) == 2 && runes[0] == 0 && runes[1] == unicode.MaxRune {
+		b.WriteString(`(?s:.)`)
+		return
+	}
+
+	b.WriteString(`[`)
+	if runes[len(runes)-1] == unicode.MaxRune {
+		b.WriteString(`^`)
+		runes = negate(runes)
+	}
+	for i := 0; i+1 < len(runes); i += 2 {
+		writeRune(b, runes[i], dialect, true)
+		if runes[i+1] != runes[i] {
+			b.WriteString(`-`)
+			writeRune(b, runes[i+1], dialect, true)
+		}
+	}
+	b.WriteString(`]`)
+}
+
+// negate returns the ranges not covered by the sorted pairs of ranges in
+// runes.
+func negate(runes []rune) []rune {
+	var negated []rune
+
+	next := rune(0)
+	for i := 0; i+1 < len(runes); i += 2 {
+		if runes[i] > next {
+			negated = append(negated, next, runes[i]-1)
+		}
+		next = runes[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		negated = append(negated, next, unicode.MaxRune)
+	}
+
+	return negated
+}
+
+func writeRune(b *strings.Builder, r rune, dialect Dialect, inClass bool) {
+	special := `\.+*?()|[]{}^$`
+	if inClass {
+		special = `\]^-[&~`
+	}
+
+	switch {
+	case r < utf8RuneSelf && strings.ContainsRune(special, r):
+		b.WriteByte('\\')
+		b.WriteRune(r)
+	case r < utf8RuneSelf && (r < ' ' || r == 0x7f):
+		fmt.Fprintf(b, `\x{%x}`, r)
+	case r < utf8RuneSelf:
+		b.WriteRune(r)
+	case dialect != PCRE && unicode.IsPrint(r):
+		b.WriteRune(r)
+	default:
+		fmt.Fprintf(b, `\x{%x}`, r)
+	}
+}
+
+const utf8RuneSelf = 0x80
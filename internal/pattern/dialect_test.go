@@ -0,0 +1,74 @@
+package pattern_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/pattern"
+)
+
+func TestTranslate(t *testing.T) {
+	a := assert.New(t)
+
+	const pcreBoundary = `(?:(?<=[0-9A-Z_a-z])(?![0-9A-Z_a-z])|(?<![0-9A-Z_a-z])(?=[0-9A-Z_a-z]))`
+
+	for _, tc := range []struct {
+		query string
+		re2   string
+		pcre  string
+		rust  string
+	}{
+		{`\Qa.b\E`, `a\.b`, `a\.b`, `a\.b`},
+		{`^x$`, `(?m:^)x(?m:$)`, `(?m:^)x(?m:$)`, `(?m:^)x(?m:$)`},
+		{`\Ax\z`, `\Ax\z`, `\Ax\z`, `\Ax\z`},
+		{`\bx\B`, `\bx\B`, pcreBoundary + `x(?:(?<=[0-9A-Z_a-z])(?=[0-9A-Z_a-z])|(?<![0-9A-Z_a-z])(?![0-9A-Z_a-z]))`, `(?-u:\b)x(?-u:\B)`},
+		{`\d+\s`, `[0-9]+[\x{9}-\x{a}\x{c}-\x{d} ]`, `[0-9]+[\x{9}-\x{a}\x{c}-\x{d} ]`, `[0-9]+[\x{9}-\x{a}\x{c}-\x{d} ]`},
+		{`[^a-c\]]`, `[^\]a-c]`, `[^\]a-c]`, `[^\]a-c]`},
+		{`.(?s:.)`, `[^\n](?s:.)`, `[^\n](?s:.)`, `[^\n](?s:.)`},
+		{`(?i)ab`, `(?i:AB)`, `(?i:AB)`, `(?i:AB)`},
+		{`(?U)(ab)+|c{2,}`, `(ab)+?|c{2,}?`, `(ab)+?|c{2,}?`, `(ab)+?|c{2,}?`},
+		{`x(?:ab|cd)*`, `x(?:ab|cd)*`, `x(?:ab|cd)*`, `x(?:ab|cd)*`},
+		{"a\nb", `a\x{a}b`, `a\x{a}b`, `a\x{a}b`},
+		{`é\x{200b}`, `é\x{200b}`, `\x{e9}\x{200b}`, `é\x{200b}`},
+		{`é.`, `é[^\n]`, `\x{e9}[^\n]`, `é[^\n]`},
+	} {
+		for _, d := range []struct {
+			dialect  pattern.Dialect
+			expected string
+		}{{pattern.RE2, tc.re2}, {pattern.PCRE, tc.pcre}, {pattern.Rust, tc.rust}} {
+			actual, err := pattern.Translate(tc.query, d.dialect)
+			if a.NoError(err, tc.query) {
+				a.Equal(d.expected, actual, tc.query)
+			}
+		}
+	}
+}
+
+func TestNeedsUTF(t *testing.T) {
+	a := assert.New(t)
+
+	for query, expected := range map[string]bool{
+		`é.`:        true,
+		`caf\x{e9}`: true,
+		`a.b`:       true,
+		`[^a]`:      true,
+		`\pL`:       true,
+		`\w+\s\d`:   false,
+		`[a-z]+`:    false,
+		`(?i)abc`:   false,
+		`[`:         false,
+	} {
+		a.Equal(expected, pattern.NeedsUTF(query), query)
+	}
+}
+
+func TestTranslateInvalid(t *testing.T) {
+	a := assert.New(t)
+
+	for _, query := range []string{`[`, `a(?=b)`, `(?<!a)b`, `\1`, `a++`} {
+		_, err := pattern.Translate(query, pattern.PCRE)
+		a.ErrorIs(err, searchfiles.ErrInvalidQuery, query)
+	}
+}
@@ -0,0 +1,174 @@
+package pattern
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// FoldCase reports whether query should be matched case-insensitively under
+// the given options. For SmartCase, this follows ripgrep: the query is
+// matched case-insensitively unless it contains an uppercase literal
+// character. Character classes and escapes like \S don't count.
+func FoldCase(query string, options searchfiles.SearchOptions) bool {
+	if options.CaseInsensitive {
+		return true
+	}
+
+	if !options.SmartCase {
+		return false
+	}
+
+	if !options.Regexp {
+		return !hasUpper([]rune(query))
+	}
+
+	re, err := syntax.Parse(query, syntax.Perl)
+	if err != nil {
+		return !hasUpper([]rune(query))
+	}
+
+	return !hasUpperLiteral(re)
+}
+
+// ResolveCase returns options with SmartCase replaced by an explicit
+// CaseInsensitive setting that holds for all of the queries. With SmartCase,
+// they're matched case-insensitively only if none of them would be matched
+// case-sensitively on their own.
+func ResolveCase(queries []string, options searchfiles.SearchOptions) searchfiles.SearchOptions {
+	if options.SmartCase && !options.CaseInsensitive {
+		fold := len(queries) > 0
+		for _, query := range queries {
+			if !FoldCase(query, options) {
+				fold = false
+				break
+			}
+		}
+		options.CaseInsensitive = fold
+	}
+
+	options.SmartCase = false
+
+	return options
+}
+
+func hasUpper(runes []rune) bool {
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasUpperLiteral(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral && hasUpper(re.Rune) {
+		return true
+	}
+
+	for _, sub := range re.Sub {
+		if hasUpperLiteral(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expression returns a Go regular expression equivalent to query under the
+// given options. Invert is not reflected in the expression; callers have to
+// handle that themselves.
+func Expression(query string, options searchfiles.SearchOptions) string {
+	expr := query
+	if !options.Regexp {
+		expr = regexp.QuoteMeta(query)
+	}
+
+	switch {
+	case options.WholeLine:
+		expr = `^(?:` + expr + `)$`
+	case options.WholeWord:
+		expr = `\b(?:` + expr + `)\b`
+	}
+
+	// ^ and $ match at the start and end of each line, even when a whole
+	// file is matched at once.
+	expr = `(?m)` + expr
+
+	if FoldCase(query, options) {
+		expr = `(?i)` + expr
+	}
+
+	return expr
+}
+
+// MatchesNewline reports whether the expression for query under the given
+// options could match a line break, and so match across lines if it's run
+// over a whole file at once. Queries that don't compile are reported as not
+// matching one.
+func MatchesNewline(query string, options searchfiles.SearchOptions) bool {
+	re, err := syntax.Parse(Expression(query, options), syntax.Perl)
+	if err != nil {
+		return false
+	}
+
+	return matchesNewline(re)
+}
+
+func matchesNewline(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '\n' {
+				return true
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+				return true
+			}
+		}
+	case syntax.OpAnyChar:
+		return true
+	}
+
+	for _, sub := range re.Sub {
+		if matchesNewline(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Literal returns the query as a plain string if, under the given options,
+// matching it only means finding those exact bytes. The second return value
+// is false if a regular expression is needed.
+func Literal(query string, options searchfiles.SearchOptions) (string, bool) {
+	if options.Regexp || options.WholeLine || options.WholeWord || options.Invert || FoldCase(query, options) {
+		return "", false
+	}
+	// Looking for the bytes would find them across lines.
+	if !options.Multiline && strings.Contains(query, "\n") {
+		return "", false
+	}
+
+	return query, true
+}
+
+// Compile compiles the result of Expression. Errors wrap
+// searchfiles.ErrInvalidQuery.
+func Compile(query string, options searchfiles.SearchOptions) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(Expression(query, options))
+	if err != nil {
+		return nil, fmt.Errorf("pattern.Compile: %w: %w", searchfiles.ErrInvalidQuery, err)
+	}
+
+	return re, nil
+}
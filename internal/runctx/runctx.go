@@ -1,58 +1,235 @@
 package runctx
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"fknsrs.biz/p/searchfiles"
 )
 
-type CheckErrorFunc func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error
+// CheckErrorFunc decides whether a command that exited unsuccessfully should
+// be treated as having failed. Returning nil means the command succeeded.
+type CheckErrorFunc func(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error
+
+// Identify returns a key for the file that program resolves to, which
+// changes when the PATH finds a different file or the file is replaced. It's
+// just program if there's no such file.
+func Identify(program string) string {
+	file, err := exec.LookPath(program)
+	if err != nil {
+		return program
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return file
+	}
+
+	return fmt.Sprintf("%s\x00%d\x00%d", file, info.Size(), info.ModTime().UnixNano())
+}
 
 func Run(ctx context.Context, program string, arguments []string, checkError CheckErrorFunc) ([]string, error) {
-	var stdout bytes.Buffer
+	lines := make([]string, 0)
+
+	if err := stream(ctx, "", program, arguments, Lines, checkError, func(line string) error {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("runctx.Run: %w", err)
+	}
+
+	return lines, nil
+}
+
+// RunRaw is like Run, but leaves surrounding whitespace on each line intact.
+// Empty lines are still dropped.
+func RunRaw(ctx context.Context, program string, arguments []string, checkError CheckErrorFunc) ([]string, error) {
+	lines := make([]string, 0)
+
+	if err := stream(ctx, "", program, arguments, Lines, checkError, func(line string) error {
+		if line != "" {
+			lines = append(lines, line)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("runctx.RunRaw: %w", err)
+	}
+
+	return lines, nil
+}
+
+// Stream runs a command and calls fn for each line it writes to stdout, as
+// soon as the line is available. Lines are passed to fn without their
+// trailing newline. If fn returns an error, the command is killed and Stream
+// returns that error.
+func Stream(ctx context.Context, program string, arguments []string, checkError CheckErrorFunc, fn func(line string) error) error {
+	if err := stream(ctx, "", program, arguments, Lines, checkError, fn); err != nil {
+		return fmt.Errorf("runctx.Stream: %w", err)
+	}
+
+	return nil
+}
+
+// StreamDir is like Stream, but runs the command in dir.
+func StreamDir(ctx context.Context, dir, program string, arguments []string, checkError CheckErrorFunc, fn func(line string) error) error {
+	if err := stream(ctx, dir, program, arguments, Lines, checkError, fn); err != nil {
+		return fmt.Errorf("runctx.StreamDir: %w", err)
+	}
+
+	return nil
+}
+
+// StreamSplit is like StreamDir, but breaks the output up according to split
+// instead of always on newlines.
+func StreamSplit(ctx context.Context, dir, program string, arguments []string, split Split, checkError CheckErrorFunc, fn func(record string) error) error {
+	if err := stream(ctx, dir, program, arguments, split, checkError, fn); err != nil {
+		return fmt.Errorf("runctx.StreamSplit: %w", err)
+	}
+
+	return nil
+}
+
+// Split says how the output of a command is broken up into records.
+type Split int
+
+const (
+	// Lines splits output on newlines.
+	Lines Split = iota
+	// NUL splits output on NUL bytes, as printed by the --null option of
+	// tools listing file names, which may themselves contain newlines.
+	NUL
+	// PrefixedLines splits output into lines that each start with a
+	// NUL-terminated file name, as printed by grep --null when showing
+	// matches. The file name is allowed to contain newlines, and is passed on
+	// with the rest of the line, including its NUL. Context group separators
+	// ("--") are passed on as their own records.
+	PrefixedLines
+)
+
+// readRecord reads the next record from rd, without its terminator. At the
+// end of the output it returns whatever's left along with io.EOF.
+func readRecord(rd *bufio.Reader, split Split) (string, error) {
+	switch split {
+	case NUL:
+		record, err := rd.ReadString(0)
+		return strings.TrimSuffix(record, "\x00"), err
+	case PrefixedLines:
+		// A separator can only come where a line starts. File names that
+		// start with one are too unlikely to worry about.
+		if b, _ := rd.Peek(3); string(b) == "--\n" {
+			_, err := rd.Discard(3)
+			return "--", err
+		}
+
+		name, err := rd.ReadString(0)
+		if err != nil {
+			return strings.TrimSuffix(name, "\n"), err
+		}
+
+		rest, err := rd.ReadString('\n')
+		return name + strings.TrimSuffix(rest, "\n"), err
+	default:
+		line, err := rd.ReadString('\n')
+		return strings.TrimSuffix(line, "\n"), err
+	}
+}
+
+func stream(ctx context.Context, dir, program string, arguments []string, split Split, checkError CheckErrorFunc, fn func(line string) error) error {
 	var stderr bytes.Buffer
 
-	cmd := exec.CommandContext(ctx, program, arguments...)
-	cmd.Stdout = &stdout
+	cmdCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, program, arguments...)
+	cmd.Dir = dir
 	cmd.Stderr = &stderr
 
-	ch := make(chan error, 1)
-	go func() {
-		ch <- cmd.Run()
-	}()
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("could not open stdout: %w: %w", searchfiles.ErrExecution, err)
+	}
 
-	select {
-	case <-ctx.Done():
-		if cmd.Process != nil {
-			cmd.Process.Kill()
+	if err := cmd.Start(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
 		}
-		return nil, fmt.Errorf("runctx.Run: %w", ctx.Err())
-	case err := <-ch:
-		if err != nil && checkError != nil {
-			err = checkError(cmd, err, &stdout, &stderr)
+
+		// A directory to run in that isn't there is the caller's mistake, and
+		// every other program would fail on it the same way.
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) && pathErr.Op == "chdir" {
+			return fmt.Errorf("command failed: %w", err)
 		}
 
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				return nil, fmt.Errorf("runctx.Run: command failed with exit code %d: %w", exitErr.ExitCode(), err)
-			}
+		return fmt.Errorf("command failed: %w: %w", searchfiles.ErrExecution, err)
+	}
+
+	var fnErr error
 
-			return nil, fmt.Errorf("runctx.Run: command failed: %w", err)
+	rd := bufio.NewReader(stdout)
+	for {
+		line, readErr := readRecord(rd, split)
+		if line != "" || (readErr == nil && split != NUL) {
+			if fnErr = fn(line); fnErr != nil {
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			fnErr = fmt.Errorf("could not read output: %w: %w", searchfiles.ErrExecution, readErr)
+			break
 		}
 	}
 
-	lines := make([]string, 0)
+	// Either we've hit the end of the output, or we're giving up on it. In
+	// the latter case this kills the process so Wait doesn't block forever.
+	if fnErr != nil {
+		cancel()
+	}
 
-	for _, e := range strings.Split(stdout.String(), "\n") {
-		e = strings.TrimSpace(e)
-		if e == "" {
-			continue
+	err = cmd.Wait()
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if fnErr != nil {
+		return fnErr
+	}
+
+	if err != nil && checkError != nil {
+		err = checkError(cmd, err, &stderr)
+	}
+
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// An exit code of -1 means the command was killed by a signal,
+			// which for a search tool means it crashed.
+			if exitErr.ExitCode() == -1 {
+				return fmt.Errorf("command killed: %w: %w", searchfiles.ErrExecution, err)
+			}
+
+			return fmt.Errorf("command failed with exit code %d: %w", exitErr.ExitCode(), err)
 		}
 
-		lines = append(lines, e)
+		return fmt.Errorf("command failed: %w", err)
 	}
 
-	return lines, nil
+	return nil
 }
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"fknsrs.biz/p/searchfiles"
 	"fknsrs.biz/p/searchfiles/internal/runctx"
 )
 
@@ -50,7 +53,7 @@ func TestRun(t *testing.T) {
 		{
 			name:    "failure via checkError",
 			command: []string{"sh", "-c", "echo test_stdout && echo test_stderr >&2 && exit 1"},
-			checkError: func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error {
+			checkError: func(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
 				return testErr
 			},
 			err:     fmt.Errorf("runctx.Run: command failed: test"),
@@ -59,11 +62,23 @@ func TestRun(t *testing.T) {
 		{
 			name:    "success via checkError",
 			command: []string{"sh", "-c", "echo test_stdout && echo test_stderr >&2 && exit 1"},
-			checkError: func(cmd *exec.Cmd, err error, stdout, stderr *bytes.Buffer) error {
+			checkError: func(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
 				return nil
 			},
 			expected: []string{"test_stdout"},
 		},
+		{
+			name:    "failure via signal",
+			command: []string{"sh", "-c", "kill -9 $$"},
+			err:     fmt.Errorf("runctx.Run: command killed: driver failed to run: signal: killed"),
+			rootErr: searchfiles.ErrExecution,
+		},
+		{
+			name:    "failure to start",
+			command: []string{"xxx-does-not-exist"},
+			err:     fmt.Errorf("runctx.Run: command failed: driver failed to run: exec: \"xxx-does-not-exist\": executable file not found in $PATH"),
+			rootErr: searchfiles.ErrExecution,
+		},
 		{
 			name: "context canceled",
 			ctx: func(ctx context.Context) context.Context {
@@ -118,3 +133,95 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestRunRaw(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	lines, err := runctx.RunRaw(context.Background(), "printf", []string{"  a  \n\n\tb\n"}, nil)
+	a.NoError(err)
+	a.Equal([]string{"  a  ", "\tb"}, lines)
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	var lines []string
+	err := runctx.Stream(context.Background(), "printf", []string{" a \n\nb\nc"}, nil, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	a.NoError(err)
+	a.Equal([]string{" a ", "", "b", "c"}, lines)
+}
+
+func TestStreamStop(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	var stopErr = fmt.Errorf("stop")
+
+	start := time.Now()
+
+	var lines []string
+	err := runctx.Stream(context.Background(), "sh", []string{"-c", "echo a; echo b; exec sleep 10"}, nil, func(line string) error {
+		lines = append(lines, line)
+		if line == "b" {
+			return stopErr
+		}
+		return nil
+	})
+	a.ErrorIs(err, stopErr)
+	a.Equal([]string{"a", "b"}, lines)
+	a.Less(time.Since(start), time.Second*5)
+}
+
+func TestStreamSplit(t *testing.T) {
+	t.Parallel()
+
+	a := assert.New(t)
+
+	for _, tc := range []struct {
+		name   string
+		split  runctx.Split
+		output string
+		want   []string
+	}{
+		{"Lines", runctx.Lines, `a\n\nb c\n`, []string{"a", "", "b c"}},
+		{"NUL", runctx.NUL, ` a \0b\nc\0\0d`, []string{" a ", "b\nc", "d"}},
+		{"PrefixedLines", runctx.PrefixedLines, `a\nb\00001:x\n--\nc\00002:y\n`, []string{"a\nb\x001:x", "--", "c\x002:y"}},
+	} {
+		var records []string
+		err := runctx.StreamSplit(context.Background(), "", "printf", []string{"%b", tc.output}, tc.split, nil, func(record string) error {
+			records = append(records, record)
+			return nil
+		})
+		a.NoError(err, tc.name)
+		a.Equal(tc.want, records, tc.name)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("xxx-does-not-exist", runctx.Identify("xxx-does-not-exist"))
+
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	program := filepath.Join(dir, "tool")
+	if err := os.WriteFile(program, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	before := runctx.Identify("tool")
+	a.Contains(before, program)
+
+	if err := os.WriteFile(program, []byte("#!/bin/sh\necho new\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a.NotEqual(before, runctx.Identify("tool"))
+}
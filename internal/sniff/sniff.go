@@ -0,0 +1,36 @@
+package sniff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// BlockSize is how much of the start of a file is checked when deciding
+// whether it's binary.
+const BlockSize = 64 * 1024
+
+// IsBinary reports whether b, the start of a file, contains a NUL byte. This
+// is the same heuristic rg and grep use.
+func IsBinary(b []byte) bool {
+	return bytes.IndexByte(b, 0) != -1
+}
+
+// File reports whether the file called name looks like binary data.
+func File(name string) (bool, error) {
+	fd, err := os.Open(name)
+	if err != nil {
+		return false, fmt.Errorf("sniff.File: %w", err)
+	}
+	defer fd.Close()
+
+	b := make([]byte, BlockSize)
+
+	n, err := io.ReadFull(fd, b)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("sniff.File: %w", err)
+	}
+
+	return IsBinary(b[:n]), nil
+}
@@ -0,0 +1,123 @@
+package version
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/runctx"
+)
+
+// Flavor is one of the programs that might be installed under a driver's
+// program name. Pattern finds it in the output of program --version, with
+// its first group matching the version number.
+type Flavor struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+var (
+	mu    sync.Mutex
+	cache = map[string]searchfiles.Version{}
+)
+
+// Probe runs program --version and returns the version of the first of
+// flavors found in what it prints. Some programs don't know --version, and
+// only say what they are in the usage they print when they fail, so that's
+// looked at too. Versions are remembered for each program, until it's
+// replaced or another one is found on the PATH.
+func Probe(ctx context.Context, program string, flavors []Flavor) (searchfiles.Version, error) {
+	key := runctx.Identify(program)
+
+	mu.Lock()
+	v, ok := cache[key]
+	mu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	var usage string
+
+	lines, err := runctx.Run(ctx, program, []string{"--version"}, func(cmd *exec.Cmd, err error, stderr *bytes.Buffer) error {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() != -1 {
+			usage = stderr.String()
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		return searchfiles.Version{}, fmt.Errorf("version.Probe: %w", err)
+	}
+
+	v, err = Find(strings.Join(lines, "\n")+"\n"+usage, flavors)
+	if err != nil {
+		return searchfiles.Version{}, fmt.Errorf("version.Probe: %s: %w", program, err)
+	}
+
+	mu.Lock()
+	cache[key] = v
+	mu.Unlock()
+
+	return v, nil
+}
+
+// Find returns the version of the first of flavors found in output.
+func Find(output string, flavors []Flavor) (searchfiles.Version, error) {
+	for _, flavor := range flavors {
+		m := flavor.Pattern.FindStringSubmatch(output)
+		if m == nil {
+			continue
+		}
+
+		v, err := Parse(m[1])
+		if err != nil {
+			return searchfiles.Version{}, fmt.Errorf("version.Find: %w", err)
+		}
+		v.Flavor = flavor.Name
+
+		return v, nil
+	}
+
+	return searchfiles.Version{}, fmt.Errorf("version.Find: unrecognised version output %q", firstLine(output))
+}
+
+// Parse reads a version number made of up to three numbers separated by
+// dots. Anything after them, like "-FreeBSD" or "-beta", is ignored.
+func Parse(s string) (searchfiles.Version, error) {
+	var parts [3]int
+
+	for i, field := range strings.SplitN(s, ".", 3) {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+
+		n, err := strconv.Atoi(field[:end])
+		if err != nil {
+			return searchfiles.Version{}, fmt.Errorf("version.Parse: %q: %w", s, err)
+		}
+		parts[i] = n
+
+		if end < len(field) {
+			break
+		}
+	}
+
+	return searchfiles.Version{Major: parts[0], Minor: parts[1], Patch: parts[2]}, nil
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i != -1 {
+		return s[:i]
+	}
+
+	return s
+}
@@ -0,0 +1,61 @@
+package version_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+	"fknsrs.biz/p/searchfiles/internal/version"
+)
+
+func TestParse(t *testing.T) {
+	a := assert.New(t)
+
+	for input, expected := range map[string]searchfiles.Version{
+		"14.1.1":        {Major: 14, Minor: 1, Patch: 1},
+		"0.10":          {Minor: 10},
+		"3":             {Major: 3},
+		"2.6.0-FreeBSD": {Major: 2, Minor: 6},
+		"1.2-beta.3":    {Major: 1, Minor: 2},
+	} {
+		v, err := version.Parse(input)
+		if a.NoError(err, input) {
+			a.Equal(expected, v, input)
+		}
+	}
+
+	_, err := version.Parse("x.1")
+	a.Error(err)
+}
+
+func TestFind(t *testing.T) {
+	a := assert.New(t)
+
+	flavors := []version.Flavor{
+		{Name: "one", Pattern: regexp.MustCompile(`one (\d+(?:\.\d+)*)`)},
+		{Name: "two", Pattern: regexp.MustCompile(`two v(\d+(?:\.\d+)*)`)},
+	}
+
+	v, err := version.Find("this is two v1.2.3\nand more", flavors)
+	if a.NoError(err) {
+		a.Equal(searchfiles.Version{Flavor: "two", Major: 1, Minor: 2, Patch: 3}, v)
+	}
+
+	_, err = version.Find("three 4.5\nand more", flavors)
+	a.EqualError(err, `version.Find: unrecognised version output "three 4.5"`)
+}
+
+func TestProbe(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := version.Probe(context.Background(), "true", []version.Flavor{{Pattern: regexp.MustCompile(`xxx-not-true (\d+)`)}})
+	if a.Error(err) {
+		a.Contains(err.Error(), "version.Probe: true: version.Find: unrecognised version output")
+	}
+
+	_, err = version.Probe(context.Background(), "xxx-does-not-exist", nil)
+	a.ErrorIs(err, searchfiles.ErrExecution)
+}
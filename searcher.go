@@ -0,0 +1,744 @@
+package searchfiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// Searcher is a set of drivers, and the one to use when no driver name is
+// given. It's safe for concurrent use, so different parts of a program can
+// each have their own, with their own preferred driver, without getting in
+// each other's way.
+type Searcher struct {
+	mu              sync.RWMutex
+	drivers         map[string]Driver
+	preferredDriver string
+	fallbackOrder   []string
+}
+
+// Default is the Searcher used by the package level functions. Drivers
+// register themselves with it when they're imported.
+var Default = NewSearcher()
+
+// NewSearcher returns a Searcher with no drivers, which prefers the native
+// driver once it's registered.
+func NewSearcher() *Searcher {
+	return &Searcher{
+		drivers:         map[string]Driver{},
+		preferredDriver: "native",
+	}
+}
+
+// Clone returns a new Searcher with the same drivers, preferred driver and
+// fallback order as s. Changes to either one don't affect the other.
+func (s *Searcher) Clone() *Searcher {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := &Searcher{
+		drivers:         make(map[string]Driver, len(s.drivers)),
+		preferredDriver: s.preferredDriver,
+		fallbackOrder:   s.fallbackOrder,
+	}
+	for k, v := range s.drivers {
+		c.drivers[k] = v
+	}
+
+	return c
+}
+
+func (s *Searcher) Register(driverName string, driver Driver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drivers[driverName] = driver
+}
+
+func (s *Searcher) DriverNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var a []string
+
+	for k := range s.drivers {
+		a = append(a, k)
+	}
+
+	return a
+}
+
+func (s *Searcher) SetPreferredDriver(driverName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.preferredDriver = driverName
+}
+
+func (s *Searcher) PreferredDriver() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.preferredDriver
+}
+
+func (s *Searcher) getDriver(driverName string) (Driver, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.drivers) == 0 {
+		return nil, fmt.Errorf("searchfiles.Searcher.getDriver: %w", ErrNoDrivers)
+	}
+
+	if driverName == "" {
+		driverName = s.preferredDriver
+	}
+
+	driver, ok := s.drivers[driverName]
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getDriver: %w", ErrUnknownDriver)
+	}
+
+	return driver, nil
+}
+
+func (s *Searcher) getStreamDriver(driverName string) (StreamDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getStreamDriver: %w", err)
+	}
+
+	streamDriver, ok := driver.(StreamDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getStreamDriver: %w", ErrUnimplemented)
+	}
+
+	return streamDriver, nil
+}
+
+func (s *Searcher) getOptionsDriver(driverName string) (OptionsDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getOptionsDriver: %w", err)
+	}
+
+	optionsDriver, ok := driver.(OptionsDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getOptionsDriver: %w", ErrUnimplemented)
+	}
+
+	return optionsDriver, nil
+}
+
+func (s *Searcher) getMultiDriver(driverName string) (MultiDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getMultiDriver: %w", err)
+	}
+
+	multiDriver, ok := driver.(MultiDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getMultiDriver: %w", ErrUnimplemented)
+	}
+
+	return multiDriver, nil
+}
+
+func (s *Searcher) getCountDriver(driverName string) (CountDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getCountDriver: %w", err)
+	}
+
+	countDriver, ok := driver.(CountDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getCountDriver: %w", ErrUnimplemented)
+	}
+
+	return countDriver, nil
+}
+
+func (s *Searcher) getContextDriver(driverName string) (ContextDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getContextDriver: %w", err)
+	}
+
+	contextDriver, ok := driver.(ContextDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getContextDriver: %w", ErrUnimplemented)
+	}
+
+	return contextDriver, nil
+}
+
+func (s *Searcher) getFSDriver(driverName string) (FSDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getFSDriver: %w", err)
+	}
+
+	fsDriver, ok := driver.(FSDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getFSDriver: %w", ErrUnimplemented)
+	}
+
+	return fsDriver, nil
+}
+
+func (s *Searcher) getMatchDriver(driverName string) (MatchDriver, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.getMatchDriver: %w", err)
+	}
+
+	matchDriver, ok := driver.(MatchDriver)
+	if !ok {
+		return nil, fmt.Errorf("searchfiles.Searcher.getMatchDriver: %w", ErrUnimplemented)
+	}
+
+	return matchDriver, nil
+}
+
+// Capabilities returns what the named driver can do. It returns
+// ErrUnimplemented if the driver doesn't say.
+func (s *Searcher) Capabilities(ctx context.Context, driverName string) (Capabilities, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return 0, fmt.Errorf("searchfiles.Searcher.Capabilities: %w", err)
+	}
+
+	capabilitiesDriver, ok := driver.(CapabilitiesDriver)
+	if !ok {
+		return 0, fmt.Errorf("searchfiles.Searcher.Capabilities: %w", ErrUnimplemented)
+	}
+
+	c, err := capabilitiesDriver.Capabilities(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("searchfiles.Searcher.Capabilities: %w", err)
+	}
+
+	return c, nil
+}
+
+// Version returns the version of the program the named driver runs. It
+// returns ErrUnimplemented for drivers that don't run one, or don't say.
+func (s *Searcher) Version(ctx context.Context, driverName string) (Version, error) {
+	driver, err := s.getDriver(driverName)
+	if err != nil {
+		return Version{}, fmt.Errorf("searchfiles.Searcher.Version: %w", err)
+	}
+
+	versionDriver, ok := driver.(VersionDriver)
+	if !ok {
+		return Version{}, fmt.Errorf("searchfiles.Searcher.Version: %w", ErrUnimplemented)
+	}
+
+	v, err := versionDriver.Version(ctx)
+	if err != nil {
+		return Version{}, fmt.Errorf("searchfiles.Searcher.Version: %w", err)
+	}
+
+	return v, nil
+}
+
+// require fails with ErrUnimplemented if driver says it can't do everything
+// in required. Drivers that don't say are left to refuse options themselves.
+func require(ctx context.Context, driver interface{}, required Capabilities) error {
+	capabilitiesDriver, ok := driver.(CapabilitiesDriver)
+	if !ok {
+		return nil
+	}
+
+	c, err := capabilitiesDriver.Capabilities(ctx)
+	if err != nil {
+		return fmt.Errorf("searchfiles.require: %w", err)
+	}
+
+	if missing := required &^ c; missing != 0 {
+		return fmt.Errorf("searchfiles.require: %s: %w", missing, ErrUnimplemented)
+	}
+
+	return nil
+}
+
+func (s *Searcher) TestDriver(ctx context.Context, driverName string) error {
+	s.mu.RLock()
+	driver, ok := s.drivers[driverName]
+	s.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("searchfiles.Searcher.TestDriver: %w", ErrUnknownDriver)
+	}
+
+	if err := driver.SelfTest(ctx); err != nil {
+		return fmt.Errorf("searchfiles.Searcher.TestDriver: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	res, err := s.SearchLiteralUsing(ctx, "", directory, query)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchLiteral: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
+	res, err := s.SearchRegexpUsing(ctx, "", directory, query)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchRegexp: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) SearchLiteralUsing(ctx context.Context, driverName string, directory, query string) ([]string, error) {
+	var a []string
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(SearchOptions{})); err != nil {
+			return err
+		}
+
+		a, err = driver.SearchLiteral(ctx, directory, query)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchLiteralUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) SearchRegexpUsing(ctx context.Context, driverName string, directory, query string) ([]string, error) {
+	var a []string
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(SearchOptions{Regexp: true})); err != nil {
+			return err
+		}
+
+		a, err = driver.SearchRegexp(ctx, directory, query)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchRegexpUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) StreamLiteral(ctx context.Context, directory, query string, fn StreamFunc) error {
+	if err := s.StreamLiteralUsing(ctx, "", directory, query, fn); err != nil {
+		return fmt.Errorf("searchfiles.Searcher.StreamLiteral: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) StreamRegexp(ctx context.Context, directory, query string, fn StreamFunc) error {
+	if err := s.StreamRegexpUsing(ctx, "", directory, query, fn); err != nil {
+		return fmt.Errorf("searchfiles.Searcher.StreamRegexp: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) StreamLiteralUsing(ctx context.Context, driverName string, directory, query string, fn StreamFunc) error {
+	var started bool
+	fn = track(fn, &started)
+
+	if err := s.fallback(ctx, driverName, &started, func(driverName string) error {
+		driver, err := s.getStreamDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(SearchOptions{})); err != nil {
+			return err
+		}
+
+		return driver.StreamLiteral(ctx, directory, query, fn)
+	}); err != nil && !errors.Is(err, ErrStop) {
+		return fmt.Errorf("searchfiles.Searcher.StreamLiteralUsing: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) StreamRegexpUsing(ctx context.Context, driverName string, directory, query string, fn StreamFunc) error {
+	var started bool
+	fn = track(fn, &started)
+
+	if err := s.fallback(ctx, driverName, &started, func(driverName string) error {
+		driver, err := s.getStreamDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(SearchOptions{Regexp: true})); err != nil {
+			return err
+		}
+
+		return driver.StreamRegexp(ctx, directory, query, fn)
+	}); err != nil && !errors.Is(err, ErrStop) {
+		return fmt.Errorf("searchfiles.Searcher.StreamRegexpUsing: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) MatchLiteral(ctx context.Context, directory, query string) ([]Match, error) {
+	res, err := s.MatchLiteralUsing(ctx, "", directory, query)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchLiteral: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) MatchRegexp(ctx context.Context, directory, query string) ([]Match, error) {
+	res, err := s.MatchRegexpUsing(ctx, "", directory, query)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchRegexp: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) MatchLiteralUsing(ctx context.Context, driverName string, directory, query string) ([]Match, error) {
+	var a []Match
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getMatchDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(SearchOptions{})); err != nil {
+			return err
+		}
+
+		a, err = driver.MatchLiteral(ctx, directory, query)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchLiteralUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) MatchRegexpUsing(ctx context.Context, driverName string, directory, query string) ([]Match, error) {
+	var a []Match
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getMatchDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(SearchOptions{Regexp: true})); err != nil {
+			return err
+		}
+
+		a, err = driver.MatchRegexp(ctx, directory, query)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchRegexpUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) SearchWithOptions(ctx context.Context, directory, query string, options SearchOptions) ([]string, error) {
+	res, err := s.SearchWithOptionsUsing(ctx, "", directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchWithOptions: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) SearchWithOptionsUsing(ctx context.Context, driverName string, directory, query string, options SearchOptions) ([]string, error) {
+	var a []string
+
+	if err := s.StreamWithOptionsUsing(ctx, driverName, directory, query, options, func(file string) error {
+		a = append(a, file)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchWithOptionsUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) StreamWithOptions(ctx context.Context, directory, query string, options SearchOptions, fn StreamFunc) error {
+	if err := s.StreamWithOptionsUsing(ctx, "", directory, query, options, fn); err != nil {
+		return fmt.Errorf("searchfiles.Searcher.StreamWithOptions: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) StreamWithOptionsUsing(ctx context.Context, driverName string, directory, query string, options SearchOptions, fn StreamFunc) error {
+	var started bool
+	fn = track(fn, &started)
+
+	if err := s.fallback(ctx, driverName, &started, func(driverName string) error {
+		driver, err := s.getOptionsDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		return driver.StreamWithOptions(ctx, directory, query, options, fn)
+	}); err != nil && !errors.Is(err, ErrStop) {
+		return fmt.Errorf("searchfiles.Searcher.StreamWithOptionsUsing: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) MatchWithOptions(ctx context.Context, directory, query string, options SearchOptions) ([]Match, error) {
+	res, err := s.MatchWithOptionsUsing(ctx, "", directory, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchWithOptions: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) MatchWithOptionsUsing(ctx context.Context, driverName string, directory, query string, options SearchOptions) ([]Match, error) {
+	var a []Match
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getOptionsDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		a, err = driver.MatchWithOptions(ctx, directory, query, options)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchWithOptionsUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) SearchMulti(ctx context.Context, directory string, queries []string, mode MultiMode, options SearchOptions) ([]MultiResult, error) {
+	res, err := s.SearchMultiUsing(ctx, "", directory, queries, mode, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchMulti: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) SearchMultiUsing(ctx context.Context, driverName string, directory string, queries []string, mode MultiMode, options SearchOptions) ([]MultiResult, error) {
+	var a []MultiResult
+
+	if err := s.StreamMultiUsing(ctx, driverName, directory, queries, mode, options, func(result MultiResult) error {
+		a = append(a, result)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchMultiUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) StreamMulti(ctx context.Context, directory string, queries []string, mode MultiMode, options SearchOptions, fn MultiFunc) error {
+	if err := s.StreamMultiUsing(ctx, "", directory, queries, mode, options, fn); err != nil {
+		return fmt.Errorf("searchfiles.Searcher.StreamMulti: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) StreamMultiUsing(ctx context.Context, driverName string, directory string, queries []string, mode MultiMode, options SearchOptions, fn MultiFunc) error {
+	var started bool
+	fn = track(fn, &started)
+
+	if err := s.fallback(ctx, driverName, &started, func(driverName string) error {
+		driver, err := s.getMultiDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		return driver.StreamMulti(ctx, directory, queries, mode, options, fn)
+	}); err != nil && !errors.Is(err, ErrStop) {
+		return fmt.Errorf("searchfiles.Searcher.StreamMultiUsing: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) CountWithOptions(ctx context.Context, directory, query string, mode CountMode, options SearchOptions) (Counts, error) {
+	res, err := s.CountWithOptionsUsing(ctx, "", directory, query, mode, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.CountWithOptions: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) CountWithOptionsUsing(ctx context.Context, driverName string, directory, query string, mode CountMode, options SearchOptions) (Counts, error) {
+	var a Counts
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getCountDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		a, err = driver.CountWithOptions(ctx, directory, query, mode, options)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.CountWithOptionsUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) MatchWithContext(ctx context.Context, directory, query string, before, after int, options SearchOptions) ([]ContextMatch, error) {
+	res, err := s.MatchWithContextUsing(ctx, "", directory, query, before, after, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchWithContext: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) MatchWithContextUsing(ctx context.Context, driverName string, directory, query string, before, after int, options SearchOptions) ([]ContextMatch, error) {
+	var a []ContextMatch
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getContextDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		a, err = driver.MatchWithContext(ctx, directory, query, before, after, options)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchWithContextUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) SearchFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions) ([]string, error) {
+	res, err := s.SearchFSUsing(ctx, "", fsys, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchFS: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) SearchFSUsing(ctx context.Context, driverName string, fsys fs.FS, query string, options SearchOptions) ([]string, error) {
+	var a []string
+
+	if err := s.StreamFSUsing(ctx, driverName, fsys, query, options, func(file string) error {
+		a = append(a, file)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.SearchFSUsing: %w", err)
+	}
+
+	return a, nil
+}
+
+func (s *Searcher) StreamFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions, fn StreamFunc) error {
+	if err := s.StreamFSUsing(ctx, "", fsys, query, options, fn); err != nil {
+		return fmt.Errorf("searchfiles.Searcher.StreamFS: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) StreamFSUsing(ctx context.Context, driverName string, fsys fs.FS, query string, options SearchOptions, fn StreamFunc) error {
+	var started bool
+	fn = track(fn, &started)
+
+	if err := s.fallback(ctx, driverName, &started, func(driverName string) error {
+		driver, err := s.getFSDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		return driver.StreamFS(ctx, fsys, query, options, fn)
+	}); err != nil && !errors.Is(err, ErrStop) {
+		return fmt.Errorf("searchfiles.Searcher.StreamFSUsing: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Searcher) MatchFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions) ([]Match, error) {
+	res, err := s.MatchFSUsing(ctx, "", fsys, query, options)
+	if err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchFS: %w", err)
+	}
+
+	return res, nil
+}
+
+func (s *Searcher) MatchFSUsing(ctx context.Context, driverName string, fsys fs.FS, query string, options SearchOptions) ([]Match, error) {
+	var a []Match
+
+	if err := s.fallback(ctx, driverName, nil, func(driverName string) error {
+		driver, err := s.getFSDriver(driverName)
+		if err != nil {
+			return err
+		}
+
+		if err := require(ctx, driver, RequiredCapabilities(options)); err != nil {
+			return err
+		}
+
+		a, err = driver.MatchFS(ctx, fsys, query, options)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("searchfiles.Searcher.MatchFSUsing: %w", err)
+	}
+
+	return a, nil
+}
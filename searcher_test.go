@@ -0,0 +1,186 @@
+package searchfiles_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+type namedDriver string
+
+func (d namedDriver) SelfTest(ctx context.Context) error {
+	return nil
+}
+
+func (d namedDriver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	return []string{string(d)}, nil
+}
+
+func (d namedDriver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
+	return []string{string(d)}, nil
+}
+
+func TestSearcherPreferredDriver(t *testing.T) {
+	a := assert.New(t)
+
+	s1 := searchfiles.NewSearcher()
+	s1.Register("one", namedDriver("one"))
+	s1.Register("two", namedDriver("two"))
+
+	s2 := s1.Clone()
+
+	s1.SetPreferredDriver("one")
+	s2.SetPreferredDriver("two")
+
+	res, err := s1.SearchLiteral(context.Background(), "/", "x")
+	a.NoError(err)
+	a.Equal([]string{"one"}, res)
+
+	res, err = s2.SearchLiteral(context.Background(), "/", "x")
+	a.NoError(err)
+	a.Equal([]string{"two"}, res)
+}
+
+func TestSearcherClone(t *testing.T) {
+	a := assert.New(t)
+
+	s1 := searchfiles.NewSearcher()
+	s1.Register("one", namedDriver("one"))
+
+	s2 := s1.Clone()
+	s2.Register("two", namedDriver("two"))
+
+	a.ElementsMatch([]string{"one"}, s1.DriverNames())
+	a.ElementsMatch([]string{"one", "two"}, s2.DriverNames())
+	a.Equal("native", s2.PreferredDriver())
+}
+
+func TestSearcherNoDrivers(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := searchfiles.NewSearcher().SearchLiteral(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrNoDrivers)
+}
+
+func TestSearcherUnknownDriver(t *testing.T) {
+	a := assert.New(t)
+
+	s := searchfiles.NewSearcher()
+	s.Register("one", namedDriver("one"))
+
+	_, err := s.SearchLiteral(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrUnknownDriver)
+
+	a.ErrorIs(s.TestDriver(context.Background(), "two"), searchfiles.ErrUnknownDriver)
+}
+
+func TestSearcherUnimplemented(t *testing.T) {
+	a := assert.New(t)
+
+	s := searchfiles.NewSearcher()
+	s.Register("native", namedDriver("native"))
+
+	_, err := s.MatchLiteral(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrUnimplemented)
+}
+
+func TestSearcherConcurrent(t *testing.T) {
+	a := assert.New(t)
+
+	s := searchfiles.NewSearcher()
+	s.Register("native", namedDriver("native"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("driver%d", i)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.Register(name, namedDriver(name))
+			s.SetPreferredDriver("native")
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := s.SearchRegexp(context.Background(), "/", "x")
+			a.NoError(err)
+			s.DriverNames()
+		}()
+	}
+	wg.Wait()
+
+	a.Len(s.DriverNames(), 11)
+}
+
+type limitedDriver struct {
+	namedDriver
+	capabilities searchfiles.Capabilities
+}
+
+func (d limitedDriver) Capabilities(ctx context.Context) (searchfiles.Capabilities, error) {
+	return d.capabilities, nil
+}
+
+func TestSearcherCapabilities(t *testing.T) {
+	a := assert.New(t)
+
+	s := searchfiles.NewSearcher()
+	s.Register("native", limitedDriver{namedDriver: "native", capabilities: searchfiles.CapabilityVCSIgnore})
+	s.Register("other", namedDriver("other"))
+
+	c, err := s.Capabilities(context.Background(), "native")
+	a.NoError(err)
+	a.Equal(searchfiles.CapabilityVCSIgnore, c)
+
+	_, err = s.Capabilities(context.Background(), "other")
+	a.ErrorIs(err, searchfiles.ErrUnimplemented)
+
+	res, err := s.SearchLiteral(context.Background(), "/", "x")
+	a.NoError(err)
+	a.Equal([]string{"native"}, res)
+
+	_, err = s.SearchRegexp(context.Background(), "/", "x")
+	a.ErrorIs(err, searchfiles.ErrUnimplemented)
+	a.Contains(err.Error(), "regexp")
+
+	res, err = s.SearchRegexpUsing(context.Background(), "other", "/", "x")
+	a.NoError(err)
+	a.Equal([]string{"other"}, res)
+}
+
+func TestRequiredCapabilities(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(searchfiles.CapabilityVCSIgnore, searchfiles.RequiredCapabilities(searchfiles.SearchOptions{}))
+	a.Equal(searchfiles.Capabilities(0), searchfiles.RequiredCapabilities(searchfiles.SearchOptions{Ignore: searchfiles.SearchEverything}))
+	a.Equal(
+		searchfiles.CapabilityRegexp|searchfiles.CapabilityMultiline|searchfiles.CapabilityEncoding,
+		searchfiles.RequiredCapabilities(searchfiles.SearchOptions{Regexp: true, Multiline: true, Encoding: searchfiles.EncodingUTF16LE, Ignore: searchfiles.SearchEverything}),
+	)
+	a.Equal("regexp, multiline", (searchfiles.CapabilityRegexp | searchfiles.CapabilityMultiline).String())
+}
+
+func TestSearcherVersion(t *testing.T) {
+	a := assert.New(t)
+
+	s := searchfiles.NewSearcher()
+	s.Register("native", namedDriver("native"))
+
+	_, err := s.Version(context.Background(), "native")
+	a.ErrorIs(err, searchfiles.ErrUnimplemented)
+}
+
+func TestVersionCompare(t *testing.T) {
+	a := assert.New(t)
+
+	v := searchfiles.Version{Flavor: "gnu", Major: 3, Minor: 8}
+	a.Equal("gnu 3.8.0", v.String())
+	a.Equal(0, v.Compare(searchfiles.Version{Major: 3, Minor: 8}))
+	a.Equal(1, v.Compare(searchfiles.Version{Major: 3, Minor: 7, Patch: 9}))
+	a.Equal(-1, v.Compare(searchfiles.Version{Major: 10}))
+}
@@ -3,12 +3,26 @@ package searchfiles
 import (
 	"context"
 	"fmt"
+	"io/fs"
+	"strings"
 )
 
 var (
-	ErrUnimplemented = fmt.Errorf("unimplemented")
-	ErrUnknownDriver = fmt.Errorf("no driver found with this name")
-	ErrNoDrivers     = fmt.Errorf("no drivers registered; try using fknsrs.biz/p/searchfiles/detect or fknsrs.biz/p/searchfiles/driver/native")
+	ErrUnimplemented   = fmt.Errorf("unimplemented")
+	ErrUnknownDriver   = fmt.Errorf("no driver found with this name")
+	ErrNoDrivers       = fmt.Errorf("no drivers registered; try using fknsrs.biz/p/searchfiles/detect or fknsrs.biz/p/searchfiles/driver/native")
+	ErrUnknownFileType = fmt.Errorf("unknown file type")
+	// ErrInvalidQuery is returned for regexps that aren't valid RE2 syntax,
+	// which is what every driver accepts, whatever the tool it runs uses.
+	ErrInvalidQuery = fmt.Errorf("invalid query")
+	// ErrExecution is wrapped by errors from drivers that couldn't run the
+	// tool they use, or that crashed while it was running, rather than
+	// anything to do with the search itself. Searches move on to the next
+	// driver in the fallback order when they get it.
+	ErrExecution = fmt.Errorf("driver failed to run")
+	// ErrStop can be returned from a StreamFunc to end a search early. The
+	// Stream functions in this package treat it as success.
+	ErrStop = fmt.Errorf("stop searching")
 )
 
 type Driver interface {
@@ -17,101 +31,539 @@ type Driver interface {
 	SearchRegexp(ctx context.Context, directory, query string) ([]string, error)
 }
 
-var (
-	drivers         = map[string]Driver{}
-	preferredDriver = "native"
+// Match is a single matching line. Path is relative to the searched
+// directory, in the same form as the results of SearchLiteral and
+// SearchRegexp.
+type Match struct {
+	Path string
+	// LineNumber is 1-based.
+	LineNumber int
+	// Offset is the byte offset of the start of the line within the file, or
+	// -1 if the driver is unable to report it.
+	Offset int64
+	// Column is the 1-based byte column of the first submatch, or 0 if there
+	// are no submatches.
+	Column int
+	// Line is the text of the line, without its line terminator. With
+	// SearchOptions.Multiline, it can be several lines, covering every match
+	// that starts or ends on one of them.
+	Line       string
+	Submatches []Submatch
+}
+
+// Submatch is one occurrence of the query within Match.Line. Start and End
+// are byte offsets into the line.
+type Submatch struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// ContextLine is a line near a match, included to show where the match is.
+type ContextLine struct {
+	// LineNumber is 1-based.
+	LineNumber int
+	// Line is the text of the line, without its line terminator.
+	Line string
+}
+
+// ContextMatch is a Match along with the lines around it. No line is
+// reported twice: when the contexts of two matches in the same file overlap,
+// the lines between them go to the After of the first, as far as it reaches,
+// and the rest to the Before of the second. Matching lines are never used as
+// context.
+type ContextMatch struct {
+	Match
+	Before []ContextLine
+	After  []ContextLine
+}
+
+// StreamFunc is called with each matching file as soon as a driver finds it.
+// Returning an error stops the search, including any underlying process.
+type StreamFunc func(file string) error
+
+type StreamDriver interface {
+	StreamLiteral(ctx context.Context, directory, query string, fn StreamFunc) error
+	StreamRegexp(ctx context.Context, directory, query string, fn StreamFunc) error
+}
+
+// SearchOptions controls how a query is interpreted and matched. The zero
+// value searches for query as a case-sensitive literal string.
+type SearchOptions struct {
+	// Regexp treats the query as a regular expression rather than a literal.
+	Regexp bool
+	// CaseInsensitive ignores case when matching.
+	CaseInsensitive bool
+	// SmartCase ignores case unless the query contains an uppercase
+	// character. CaseInsensitive takes precedence over it.
+	SmartCase bool
+	// WholeWord only matches the query when it's surrounded by word
+	// boundaries.
+	WholeWord bool
+	// WholeLine only matches the query when it spans an entire line. It takes
+	// precedence over WholeWord.
+	WholeLine bool
+	// Invert selects lines that don't match the query. When listing files,
+	// this means files with at least one non-matching line, as with grep -v.
+	Invert bool
+	// FilesWithoutMatch lists the files that don't match the query instead
+	// of those that do, as with grep -L. Binary files are still skipped
+	// unless Binary is set. It only applies to listing files, so it has no
+	// effect on MatchWithOptions, counts or multi-pattern searches.
+	FilesWithoutMatch bool
+	// Multiline lets the query match across lines, as with rg --multiline. A
+	// line break in the query, or \n in a regexp, matches the end of a line,
+	// while ^ and $ still match at the start and end of any line, and .
+	// doesn't match a line break. Without it, nothing matches across lines.
+	// It can't be combined with Invert.
+	Multiline bool
+
+	// Include limits the search to files matching at least one of these
+	// globs. Globs follow gitignore rules: without a slash they match a file
+	// name at any depth, with one they match the path relative to the
+	// searched directory, and "**" matches any number of directories.
+	Include []string
+	// Exclude skips files and directories matching any of these globs.
+	// Excluded directories aren't descended into.
+	Exclude []string
+	// Types limits the search to files of the named types, as defined in
+	// FileTypes. When used with Include, files have to satisfy both.
+	Types []string
+
+	// Ignore controls whether ignore files and hidden files are respected.
+	Ignore IgnorePolicy
+	// Binary searches files that look like binary data as though they were
+	// text. By default, files with a NUL byte near the start are skipped, as
+	// rg and grep --binary-files=without-match do.
+	Binary bool
+	// Encoding decides how the contents of files are decoded before they're
+	// searched.
+	Encoding Encoding
+}
+
+// Encoding is a text encoding to read files as. Drivers that can't transcode
+// return ErrUnimplemented for anything other than EncodingAuto and
+// EncodingUTF8, and search files as they are with either of those.
+type Encoding int
+
+// Whatever the encoding, a file that starts with a byte order mark is read as
+// the mark says, as rg does, and the mark itself isn't searched.
+const (
+	// EncodingAuto reads files as UTF-8 unless they start with a byte order
+	// mark.
+	EncodingAuto Encoding = iota
+	// EncodingUTF8 reads files as UTF-8.
+	EncodingUTF8
+	// EncodingLatin1 reads files as ISO-8859-1, where every byte is a
+	// character.
+	EncodingLatin1
+	// EncodingUTF16LE reads files as little-endian UTF-16.
+	EncodingUTF16LE
+	// EncodingUTF16BE reads files as big-endian UTF-16.
+	EncodingUTF16BE
 )
 
-func Register(driverName string, driver Driver) {
-	drivers[driverName] = driver
+// IgnorePolicy decides which files are skipped before any searching happens.
+// The zero value behaves like rg, ag and pt do by default: patterns in
+// .gitignore and .ignore files are respected, and hidden files and
+// directories (those whose names start with a dot) are skipped. Ignore files
+// are read from the searched directory and everything below it, but not from
+// the directories above it.
+type IgnorePolicy struct {
+	// NoVCSIgnore searches files excluded by .gitignore files.
+	NoVCSIgnore bool
+	// NoIgnoreFiles searches files excluded by .ignore files.
+	NoIgnoreFiles bool
+	// Hidden searches hidden files and directories.
+	Hidden bool
 }
 
-func DriverNames() []string {
-	var a []string
+// SearchEverything is an IgnorePolicy that doesn't skip anything.
+var SearchEverything = IgnorePolicy{NoVCSIgnore: true, NoIgnoreFiles: true, Hidden: true}
 
-	for k := range drivers {
-		a = append(a, k)
-	}
+// FileTypes maps the names that can be used in SearchOptions.Types to the
+// globs that make up each type.
+var FileTypes = map[string][]string{
+	"c":        {"*.c", "*.h"},
+	"cpp":      {"*.cc", "*.cpp", "*.cxx", "*.hh", "*.hpp", "*.hxx"},
+	"css":      {"*.css", "*.scss", "*.sass", "*.less"},
+	"go":       {"*.go"},
+	"html":     {"*.htm", "*.html"},
+	"java":     {"*.java"},
+	"js":       {"*.js", "*.jsx", "*.mjs", "*.cjs"},
+	"json":     {"*.json"},
+	"markdown": {"*.md", "*.markdown"},
+	"proto":    {"*.proto"},
+	"py":       {"*.py", "*.pyi"},
+	"rust":     {"*.rs"},
+	"sh":       {"*.sh", "*.bash", "*.zsh"},
+	"sql":      {"*.sql"},
+	"ts":       {"*.ts", "*.tsx", "*.mts", "*.cts"},
+	"txt":      {"*.txt"},
+	"xml":      {"*.xml"},
+	"yaml":     {"*.yaml", "*.yml"},
+}
 
-	return a
+type OptionsDriver interface {
+	StreamWithOptions(ctx context.Context, directory, query string, options SearchOptions, fn StreamFunc) error
+	MatchWithOptions(ctx context.Context, directory, query string, options SearchOptions) ([]Match, error)
 }
 
-func SetPreferredDriver(driverName string) {
-	preferredDriver = driverName
+// MultiMode decides how the patterns in a multi-pattern search combine.
+type MultiMode int
+
+const (
+	// AnyOf selects files matching at least one of the patterns.
+	AnyOf MultiMode = iota
+	// AllOf selects files matching every one of the patterns.
+	AllOf
+)
+
+// MultiResult is a file found by a multi-pattern search. Patterns holds the
+// indexes of the patterns that matched somewhere in the file, in ascending
+// order.
+type MultiResult struct {
+	Path     string
+	Patterns []int
 }
 
-func getDriver(driverName string) (Driver, error) {
-	if len(drivers) == 0 {
-		return nil, fmt.Errorf("searchfiles.getDriver: %w", ErrNoDrivers)
-	}
+// MultiFunc is called with each file found by a multi-pattern search.
+// Returning an error stops the search, as with StreamFunc.
+type MultiFunc func(result MultiResult) error
 
-	if driverName == "" {
-		driverName = preferredDriver
-	}
+// MultiDriver searches for several patterns in one pass over the tree.
+// Every pattern is interpreted according to options, and patterns are
+// matched line by line. With SmartCase, the patterns are matched
+// case-insensitively only if none of them contain an uppercase character.
+type MultiDriver interface {
+	StreamMulti(ctx context.Context, directory string, queries []string, mode MultiMode, options SearchOptions, fn MultiFunc) error
+}
 
-	driver, ok := drivers[driverName]
-	if !ok {
-		return nil, fmt.Errorf("searchfiles.getDriver: %w", ErrUnknownDriver)
+// CountMode selects what's counted in each file by a count search.
+type CountMode int
+
+const (
+	// CountLines counts matching lines, as with grep -c. With Multiline,
+	// that's every line a match covers.
+	CountLines CountMode = iota
+	// CountOccurrences counts every match, so a line matching twice counts
+	// twice, as with rg --count-matches. With Invert, there's nothing to
+	// count within a line, so it counts lines.
+	CountOccurrences
+)
+
+// Counts maps the path of each matching file to the number of matches found
+// in it. Files without any matches are left out.
+type Counts map[string]int
+
+// Total returns the number of matches across all files.
+func (c Counts) Total() int {
+	n := 0
+	for _, v := range c {
+		n += v
 	}
 
-	return driver, nil
+	return n
 }
 
-func TestDriver(ctx context.Context, driverName string) error {
-	driver, ok := drivers[driverName]
-	if !ok {
-		return fmt.Errorf("searchfiles.TestDriver: %w", ErrUnknownDriver)
-	}
+type CountDriver interface {
+	CountWithOptions(ctx context.Context, directory, query string, mode CountMode, options SearchOptions) (Counts, error)
+}
 
-	if err := driver.SelfTest(ctx); err != nil {
-		return fmt.Errorf("searchfiles.TestDriver: %w", err)
-	}
+// ContextDriver finds matching lines along with up to before lines
+// preceding them and after lines following them, like grep -B and -A.
+type ContextDriver interface {
+	MatchWithContext(ctx context.Context, directory, query string, before, after int, options SearchOptions) ([]ContextMatch, error)
+}
 
-	return nil
+// FSDriver searches the files in an fs.FS, such as an embed.FS, a
+// zip.Reader or an fstest.MapFS, rather than a directory on disk. Paths are
+// reported the same way as for a directory, starting with a slash.
+type FSDriver interface {
+	StreamFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions, fn StreamFunc) error
+	MatchFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions) ([]Match, error)
 }
 
-func SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
-	res, err := SearchLiteralUsing(ctx, "", directory, query)
-	if err != nil {
-		return nil, fmt.Errorf("searchfiles.SearchLiteral: %w", err)
+type MatchDriver interface {
+	MatchLiteral(ctx context.Context, directory, query string) ([]Match, error)
+	MatchRegexp(ctx context.Context, directory, query string) ([]Match, error)
+}
+
+// Capabilities is a set of things a driver can do, beyond finding literal
+// strings.
+type Capabilities uint
+
+const (
+	// CapabilityRegexp is needed for regexp queries.
+	CapabilityRegexp Capabilities = 1 << iota
+	// CapabilityInvert is needed for SearchOptions.Invert.
+	CapabilityInvert
+	// CapabilityMultiline is needed for SearchOptions.Multiline.
+	CapabilityMultiline
+	// CapabilityBinary is needed for SearchOptions.Binary.
+	CapabilityBinary
+	// CapabilityEncoding is needed for an Encoding other than EncodingAuto
+	// and EncodingUTF8.
+	CapabilityEncoding
+	// CapabilityVCSIgnore means .gitignore files are respected, unless
+	// IgnorePolicy.NoVCSIgnore is set.
+	CapabilityVCSIgnore
+	// CapabilityDecompress means the driver can be set up to search the
+	// contents of compressed files.
+	CapabilityDecompress
+	// CapabilityArchives means the driver can be set up to search the files
+	// inside archives.
+	CapabilityArchives
+)
+
+// Has reports whether c includes everything in other.
+func (c Capabilities) Has(other Capabilities) bool {
+	return c&other == other
+}
+
+var capabilityNames = []string{"regexp", "invert", "multiline", "binary", "encoding", "vcs ignore", "decompress", "archives"}
+
+func (c Capabilities) String() string {
+	var names []string
+
+	for i, name := range capabilityNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
 	}
 
-	return res, nil
+	return strings.Join(names, ", ")
 }
 
-func SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
-	res, err := SearchRegexpUsing(ctx, "", directory, query)
-	if err != nil {
-		return nil, fmt.Errorf("searchfiles.SearchRegexp: %w", err)
+// RequiredCapabilities returns what a driver has to be able to do to search
+// with options.
+func RequiredCapabilities(options SearchOptions) Capabilities {
+	var c Capabilities
+
+	if options.Regexp {
+		c |= CapabilityRegexp
+	}
+	if options.Invert {
+		c |= CapabilityInvert
+	}
+	if options.Multiline {
+		c |= CapabilityMultiline
+	}
+	if options.Binary {
+		c |= CapabilityBinary
+	}
+	if options.Encoding != EncodingAuto && options.Encoding != EncodingUTF8 {
+		c |= CapabilityEncoding
+	}
+	if !options.Ignore.NoVCSIgnore {
+		c |= CapabilityVCSIgnore
 	}
 
-	return res, nil
+	return c
 }
 
-func SearchLiteralUsing(ctx context.Context, driverName string, directory, query string) ([]string, error) {
-	driver, err := getDriver(driverName)
-	if err != nil {
-		return nil, fmt.Errorf("searchfiles.SearchLiteralUsing: %w", err)
+// CapabilitiesDriver is implemented by drivers that can say what they're
+// able to do. For drivers that run another program, that can depend on which
+// version of it is installed, and how it was built. Searches that need
+// something a driver says it can't do fail with ErrUnimplemented rather than
+// being run without it.
+type CapabilitiesDriver interface {
+	Capabilities(ctx context.Context) (Capabilities, error)
+}
+
+// Version is the version of the program a driver runs.
+type Version struct {
+	// Flavor tells apart programs that go by the same name, such as "gnu",
+	// "bsd" and "busybox" for grep. It's empty for programs that only come
+	// in one.
+	Flavor string
+	Major  int
+	Minor  int
+	Patch  int
+}
+
+// Compare returns -1, 0 or 1 depending on whether v is older than, the same
+// as or newer than other. Flavors aren't compared.
+func (v Version) Compare(other Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
 	}
 
-	a, err := driver.SearchLiteral(ctx, directory, query)
-	if err != nil {
-		return nil, fmt.Errorf("searchfiles.SearchLiteralUsing: %w", err)
+	return 0
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Flavor != "" {
+		s = v.Flavor + " " + s
 	}
 
-	return a, nil
+	return s
+}
+
+// VersionDriver is implemented by drivers that run another program, to find
+// out which version of it is installed.
+type VersionDriver interface {
+	Version(ctx context.Context) (Version, error)
+}
+
+func Register(driverName string, driver Driver) {
+	Default.Register(driverName, driver)
+}
+
+func DriverNames() []string {
+	return Default.DriverNames()
+}
+
+func SetPreferredDriver(driverName string) {
+	Default.SetPreferredDriver(driverName)
+}
+
+func SetFallbackOrder(driverNames []string) {
+	Default.SetFallbackOrder(driverNames)
+}
+
+func DriverCapabilities(ctx context.Context, driverName string) (Capabilities, error) {
+	return Default.Capabilities(ctx, driverName)
+}
+
+func DriverVersion(ctx context.Context, driverName string) (Version, error) {
+	return Default.Version(ctx, driverName)
+}
+
+func TestDriver(ctx context.Context, driverName string) error {
+	return Default.TestDriver(ctx, driverName)
+}
+
+func SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	return Default.SearchLiteral(ctx, directory, query)
+}
+
+func SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
+	return Default.SearchRegexp(ctx, directory, query)
+}
+
+func SearchLiteralUsing(ctx context.Context, driverName string, directory, query string) ([]string, error) {
+	return Default.SearchLiteralUsing(ctx, driverName, directory, query)
 }
 
 func SearchRegexpUsing(ctx context.Context, driverName string, directory, query string) ([]string, error) {
-	driver, err := getDriver(driverName)
-	if err != nil {
-		return nil, fmt.Errorf("searchfiles.SearchRegexpUsing: %w", err)
-	}
+	return Default.SearchRegexpUsing(ctx, driverName, directory, query)
+}
 
-	a, err := driver.SearchRegexp(ctx, directory, query)
-	if err != nil {
-		return nil, fmt.Errorf("searchfiles.SearchRegexpUsing: %w", err)
-	}
+func StreamLiteral(ctx context.Context, directory, query string, fn StreamFunc) error {
+	return Default.StreamLiteral(ctx, directory, query, fn)
+}
+
+func StreamRegexp(ctx context.Context, directory, query string, fn StreamFunc) error {
+	return Default.StreamRegexp(ctx, directory, query, fn)
+}
+
+func StreamLiteralUsing(ctx context.Context, driverName string, directory, query string, fn StreamFunc) error {
+	return Default.StreamLiteralUsing(ctx, driverName, directory, query, fn)
+}
+
+func StreamRegexpUsing(ctx context.Context, driverName string, directory, query string, fn StreamFunc) error {
+	return Default.StreamRegexpUsing(ctx, driverName, directory, query, fn)
+}
+
+func MatchLiteral(ctx context.Context, directory, query string) ([]Match, error) {
+	return Default.MatchLiteral(ctx, directory, query)
+}
+
+func MatchRegexp(ctx context.Context, directory, query string) ([]Match, error) {
+	return Default.MatchRegexp(ctx, directory, query)
+}
+
+func MatchLiteralUsing(ctx context.Context, driverName string, directory, query string) ([]Match, error) {
+	return Default.MatchLiteralUsing(ctx, driverName, directory, query)
+}
+
+func MatchRegexpUsing(ctx context.Context, driverName string, directory, query string) ([]Match, error) {
+	return Default.MatchRegexpUsing(ctx, driverName, directory, query)
+}
+
+func SearchWithOptions(ctx context.Context, directory, query string, options SearchOptions) ([]string, error) {
+	return Default.SearchWithOptions(ctx, directory, query, options)
+}
+
+func SearchWithOptionsUsing(ctx context.Context, driverName string, directory, query string, options SearchOptions) ([]string, error) {
+	return Default.SearchWithOptionsUsing(ctx, driverName, directory, query, options)
+}
+
+func StreamWithOptions(ctx context.Context, directory, query string, options SearchOptions, fn StreamFunc) error {
+	return Default.StreamWithOptions(ctx, directory, query, options, fn)
+}
+
+func StreamWithOptionsUsing(ctx context.Context, driverName string, directory, query string, options SearchOptions, fn StreamFunc) error {
+	return Default.StreamWithOptionsUsing(ctx, driverName, directory, query, options, fn)
+}
+
+func MatchWithOptions(ctx context.Context, directory, query string, options SearchOptions) ([]Match, error) {
+	return Default.MatchWithOptions(ctx, directory, query, options)
+}
+
+func MatchWithOptionsUsing(ctx context.Context, driverName string, directory, query string, options SearchOptions) ([]Match, error) {
+	return Default.MatchWithOptionsUsing(ctx, driverName, directory, query, options)
+}
+
+func SearchMulti(ctx context.Context, directory string, queries []string, mode MultiMode, options SearchOptions) ([]MultiResult, error) {
+	return Default.SearchMulti(ctx, directory, queries, mode, options)
+}
+
+func SearchMultiUsing(ctx context.Context, driverName string, directory string, queries []string, mode MultiMode, options SearchOptions) ([]MultiResult, error) {
+	return Default.SearchMultiUsing(ctx, driverName, directory, queries, mode, options)
+}
+
+func StreamMulti(ctx context.Context, directory string, queries []string, mode MultiMode, options SearchOptions, fn MultiFunc) error {
+	return Default.StreamMulti(ctx, directory, queries, mode, options, fn)
+}
+
+func StreamMultiUsing(ctx context.Context, driverName string, directory string, queries []string, mode MultiMode, options SearchOptions, fn MultiFunc) error {
+	return Default.StreamMultiUsing(ctx, driverName, directory, queries, mode, options, fn)
+}
+
+func CountWithOptions(ctx context.Context, directory, query string, mode CountMode, options SearchOptions) (Counts, error) {
+	return Default.CountWithOptions(ctx, directory, query, mode, options)
+}
+
+func CountWithOptionsUsing(ctx context.Context, driverName string, directory, query string, mode CountMode, options SearchOptions) (Counts, error) {
+	return Default.CountWithOptionsUsing(ctx, driverName, directory, query, mode, options)
+}
+
+func MatchWithContext(ctx context.Context, directory, query string, before, after int, options SearchOptions) ([]ContextMatch, error) {
+	return Default.MatchWithContext(ctx, directory, query, before, after, options)
+}
+
+func MatchWithContextUsing(ctx context.Context, driverName string, directory, query string, before, after int, options SearchOptions) ([]ContextMatch, error) {
+	return Default.MatchWithContextUsing(ctx, driverName, directory, query, before, after, options)
+}
+
+func SearchFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions) ([]string, error) {
+	return Default.SearchFS(ctx, fsys, query, options)
+}
+
+func SearchFSUsing(ctx context.Context, driverName string, fsys fs.FS, query string, options SearchOptions) ([]string, error) {
+	return Default.SearchFSUsing(ctx, driverName, fsys, query, options)
+}
+
+func StreamFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions, fn StreamFunc) error {
+	return Default.StreamFS(ctx, fsys, query, options, fn)
+}
+
+func StreamFSUsing(ctx context.Context, driverName string, fsys fs.FS, query string, options SearchOptions, fn StreamFunc) error {
+	return Default.StreamFSUsing(ctx, driverName, fsys, query, options, fn)
+}
+
+func MatchFS(ctx context.Context, fsys fs.FS, query string, options SearchOptions) ([]Match, error) {
+	return Default.MatchFS(ctx, fsys, query, options)
+}
 
-	return a, nil
+func MatchFSUsing(ctx context.Context, driverName string, fsys fs.FS, query string, options SearchOptions) ([]Match, error) {
+	return Default.MatchFSUsing(ctx, driverName, fsys, query, options)
 }
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/fs"
+	"path"
+	"reflect"
+	"runtime"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+
+	"fknsrs.biz/p/searchfiles"
+)
+
+// testFS holds the same files as tests/data, so the same expectations apply
+// to both.
+var testFS = fstest.MapFS{
+	" notes .txt":      {Data: []byte("This is a test file with spaces in its name.\n")},
+	"file1.txt":        {Data: []byte("This is a test file.")},
+	"file2.txt":        {Data: []byte("This is another test file.")},
+	"file4.txt":        {Data: []byte("This is a test file with a phone number: 123-456-7890.\n")},
+	"lines.txt":        {Data: []byte("first line\n  second line with beta and beta\nthird\n\tfourth beta\n")},
+	"subdir/file3.txt": {Data: []byte("This is a test file in a subdirectory.")},
+}
+
+func Test_AllFS(driver searchfiles.FSDriver, t *testing.T) {
+	for _, fn := range []func(driver searchfiles.FSDriver, t *testing.T){
+		Test_StreamFS_PositiveCases,
+		Test_StreamFS_QueryNotFound,
+		Test_StreamFS_RootDirNotFound,
+		Test_StreamFS_Stop,
+		Test_StreamFS_Options,
+		Test_StreamFS_FilesWithoutMatch,
+		Test_StreamFS_Ignore,
+		Test_StreamFS_BinarySkipped,
+		Test_StreamFS_Sub,
+		Test_StreamFS_Zip,
+		Test_MatchFS_Positions,
+		Test_MatchFS_InvalidRegex,
+	} {
+		pc := reflect.ValueOf(fn).Pointer()
+		f := runtime.FuncForPC(pc)
+		t.Run(path.Base(f.Name()), func(t *testing.T) { fn(driver, t) })
+	}
+}
+
+func streamFS(driver searchfiles.FSDriver, fsys fs.FS, query string, options searchfiles.SearchOptions) ([]string, error) {
+	var results []string
+	err := driver.StreamFS(context.Background(), fsys, query, options, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+
+	return results, err
+}
+
+func Test_StreamFS_PositiveCases(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	results, err := streamFS(driver, testFS, "test", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_StreamFS_QueryNotFound(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	results, err := streamFS(driver, testFS, "notfound", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_StreamFS_RootDirNotFound(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+
+	fsys, err := fs.Sub(testFS, "directory-does-not-exist")
+	if !a.NoError(err) {
+		return
+	}
+
+	results, err := streamFS(driver, fsys, "test", searchfiles.SearchOptions{})
+	a.Error(err)
+	a.Empty(results)
+}
+
+func Test_StreamFS_Stop(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := driver.StreamFS(context.Background(), testFS, "test", searchfiles.SearchOptions{}, func(file string) error {
+		results = append(results, file)
+		return searchfiles.ErrStop
+	})
+	a.ErrorIs(err, searchfiles.ErrStop)
+	a.Len(results, 1)
+}
+
+func Test_StreamFS_Options(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	results, err := streamFS(driver, testFS, "TEST", searchfiles.SearchOptions{CaseInsensitive: true, Exclude: []string{"subdir/", "file1.txt"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file2.txt", "/file4.txt"}, results)
+}
+
+func Test_StreamFS_FilesWithoutMatch(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	results, err := streamFS(driver, testFS, `be+ta|phone`, searchfiles.SearchOptions{Regexp: true, FilesWithoutMatch: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_StreamFS_Ignore(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+
+	fsys := fstest.MapFS{
+		".gitignore":        {Data: []byte("ignored.txt\nbuild/\n")},
+		".hidden.txt":       {Data: []byte("needle\n")},
+		"build/output.txt":  {Data: []byte("needle\n")},
+		"ignored.txt":       {Data: []byte("needle\n")},
+		"visible.txt":       {Data: []byte("needle\n")},
+		"sub/ignored.txt":   {Data: []byte("needle\n")},
+		"sub/.ignore":       {Data: []byte("!ignored.txt\n")},
+		"sub/something.txt": {Data: []byte("haystack\n")},
+	}
+
+	results, err := streamFS(driver, fsys, "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/sub/ignored.txt", "/visible.txt"}, results)
+
+	results, err = streamFS(driver, fsys, "needle", searchfiles.SearchOptions{Ignore: searchfiles.SearchEverything})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/.hidden.txt", "/build/output.txt", "/ignored.txt", "/sub/ignored.txt", "/visible.txt"}, results)
+}
+
+func Test_StreamFS_BinarySkipped(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+
+	fsys := fstest.MapFS{
+		"data.bin": {Data: []byte("head\x00\x01\x02needle\n")},
+		"text.txt": {Data: []byte("needle\n")},
+	}
+
+	results, err := streamFS(driver, fsys, "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal([]string{"/text.txt"}, results)
+
+	results, err = streamFS(driver, fsys, "needle", searchfiles.SearchOptions{Binary: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/data.bin", "/text.txt"}, results)
+}
+
+func Test_StreamFS_Sub(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+
+	fsys, err := fs.Sub(testFS, "subdir")
+	if !a.NoError(err) {
+		return
+	}
+
+	results, err := streamFS(driver, fsys, "test", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal([]string{"/file3.txt"}, results)
+}
+
+func Test_StreamFS_Zip(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"file1.txt", "file2.txt", "lines.txt", "subdir/file3.txt"} {
+		w, err := zw.Create(name)
+		if !a.NoError(err) {
+			return
+		}
+		if _, err := w.Write(testFS[name].Data); !a.NoError(err) {
+			return
+		}
+	}
+	if !a.NoError(zw.Close()) {
+		return
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if !a.NoError(err) {
+		return
+	}
+
+	results, err := streamFS(driver, zr, "test", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/file1.txt", "/file2.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_MatchFS_Positions(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	results, err := driver.MatchFS(context.Background(), testFS, "beta", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.ElementsMatch(expectedMatches(results), results)
+}
+
+func Test_MatchFS_InvalidRegex(driver searchfiles.FSDriver, t *testing.T) {
+	a := assert.New(t)
+	results, err := driver.MatchFS(context.Background(), testFS, `[`, searchfiles.SearchOptions{Regexp: true})
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
+	a.Empty(results)
+}
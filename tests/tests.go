@@ -1,12 +1,19 @@
 package tests
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -18,17 +25,121 @@ func getRoot() string {
 	return filepath.Join(filepath.Dir(filename), "data")
 }
 
+func getIgnoreRoot() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "ignore")
+}
+
+func getBinaryRoot() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "binary")
+}
+
+func getCompressedRoot() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "compressed")
+}
+
+func getEncodingRoot() string {
+	_, filename, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(filename), "encoding")
+}
+
 func Test_All(driver searchfiles.Driver, t *testing.T) {
 	for _, fn := range []func(driver searchfiles.Driver, t *testing.T){
 		Test_SearchLiteral_PositiveCases,
 		Test_SearchLiteral_QueryNotFound,
 		Test_SearchLiteral_RootDirNotFound,
+		Test_SearchLiteral_RootDirNotFoundNoFallback,
+		Test_SearchLiteral_HiddenRoot,
 		Test_SearchLiteral_QueryNotLiteralMatch,
+		Test_SearchLiteral_AwkwardNames,
 		Test_SearchRegexp_PositiveCaseSingleFile,
 		Test_SearchRegexp_PositiveCaseMultipleFiles,
 		Test_SearchRegexp_QueryNotFound,
 		Test_SearchRegexp_InvalidRegex,
+		Test_SearchRegexp_Lookaround,
+		Test_SearchRegexp_Dialect,
+		Test_SearchRegexp_LineAnchors,
 		Test_SearchRegexp_RootDirNotFound,
+		Test_StreamLiteral_PositiveCases,
+		Test_StreamLiteral_Stop,
+		Test_StreamLiteral_RootDirNotFound,
+		Test_StreamRegexp_CancelLargeFile,
+		Test_StreamRegexp_PositiveCaseMultipleFiles,
+		Test_StreamRegexp_InvalidRegex,
+		Test_SearchWithOptions_CaseInsensitive,
+		Test_SearchWithOptions_SmartCaseLower,
+		Test_SearchWithOptions_SmartCaseUpper,
+		Test_SearchWithOptions_SmartCaseClass,
+		Test_SearchWithOptions_WholeWord,
+		Test_SearchWithOptions_WholeWordPartial,
+		Test_SearchWithOptions_WholeLine,
+		Test_SearchWithOptions_WholeLineRegexp,
+		Test_SearchWithOptions_Invert,
+		Test_SearchWithOptions_IncludeName,
+		Test_SearchWithOptions_IncludePath,
+		Test_SearchWithOptions_ExcludeDirectory,
+		Test_SearchWithOptions_ExcludeDirectoryContents,
+		Test_SearchWithOptions_ExcludeFile,
+		Test_SearchWithOptions_IncludeAndExclude,
+		Test_SearchWithOptions_Types,
+		Test_SearchWithOptions_TypesNoMatch,
+		Test_SearchWithOptions_UnknownType,
+		Test_SearchWithOptions_IgnoreDefault,
+		Test_SearchWithOptions_IgnoreNoVCSIgnore,
+		Test_SearchWithOptions_IgnoreNoIgnoreFiles,
+		Test_SearchWithOptions_IgnoreHidden,
+		Test_SearchWithOptions_IgnoreNothing,
+		Test_SearchWithOptions_FilesWithoutMatch,
+		Test_SearchWithOptions_FilesWithoutMatchRegexp,
+		Test_SearchWithOptions_FilesWithoutMatchAll,
+		Test_SearchWithOptions_FilesWithoutMatchInvert,
+		Test_SearchWithOptions_FilesWithoutMatchBinary,
+		Test_SearchWithOptions_FilesWithoutMatchAwkwardNames,
+		Test_SearchWithOptions_BinarySkipped,
+		Test_SearchWithOptions_BinarySkippedRegexp,
+		Test_SearchWithOptions_Binary,
+		Test_SearchWithOptions_Multiline,
+		Test_SearchWithOptions_MultilineLiteral,
+		Test_SearchWithOptions_MultilineAnchors,
+		Test_SearchWithOptions_MultilineBinarySkipped,
+		Test_MatchWithOptions_IgnoreDefault,
+		Test_MatchWithOptions_CaseInsensitive,
+		Test_MatchWithOptions_Exclude,
+		Test_MatchWithOptions_Invert,
+		Test_MatchWithOptions_BinarySkipped,
+		Test_MatchWithOptions_Multiline,
+		Test_SearchWithOptions_EncodingAuto,
+		Test_SearchWithOptions_EncodingLatin1,
+		Test_SearchWithOptions_EncodingUTF16,
+		Test_MatchWithOptions_EncodingAuto,
+		Test_CountWithOptions_Lines,
+		Test_CountWithOptions_Occurrences,
+		Test_CountWithOptions_AwkwardNames,
+		Test_CountWithOptions_ManyFiles,
+		Test_CountWithOptions_Invert,
+		Test_CountWithOptions_QueryNotFound,
+		Test_CountWithOptions_Multiline,
+		Test_StreamMulti_AnyOf,
+		Test_StreamMulti_AllOf,
+		Test_StreamMulti_Regexp,
+		Test_StreamMulti_SmartCase,
+		Test_StreamMulti_ManyPatterns,
+		Test_StreamMulti_Stop,
+		Test_MatchWithContext_Overlapping,
+		Test_MatchWithContext_BeforeAndAfter,
+		Test_MatchWithContext_NoContext,
+		Test_MatchWithContext_QueryNotFound,
+		Test_MatchWithContext_Multiline,
+		Test_MatchLiteral_Positions,
+		Test_MatchLiteral_QueryNotFound,
+		Test_MatchLiteral_RootDirNotFound,
+		Test_MatchLiteral_AwkwardNames,
+		Test_MatchRegexp_Positions,
+		Test_MatchRegexp_InvalidRegex,
+		Test_Capabilities,
+		Test_Version,
 	} {
 		pc := reflect.ValueOf(fn).Pointer()
 		f := runtime.FuncForPC(pc)
@@ -40,7 +151,7 @@ func Test_SearchLiteral_PositiveCases(driver searchfiles.Driver, t *testing.T) {
 	a := assert.New(t)
 	results, err := driver.SearchLiteral(context.Background(), getRoot(), "test")
 	a.NoError(err)
-	a.ElementsMatch([]string{"/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
 }
 
 func Test_SearchLiteral_QueryNotFound(driver searchfiles.Driver, t *testing.T) {
@@ -57,6 +168,64 @@ func Test_SearchLiteral_RootDirNotFound(driver searchfiles.Driver, t *testing.T)
 	a.Empty(results)
 }
 
+// otherDriver stands in for the driver a search would fall back to.
+type otherDriver struct {
+	used *bool
+}
+
+func (d otherDriver) SelfTest(ctx context.Context) error {
+	return nil
+}
+
+func (d otherDriver) SearchLiteral(ctx context.Context, directory, query string) ([]string, error) {
+	*d.used = true
+	return nil, nil
+}
+
+func (d otherDriver) SearchRegexp(ctx context.Context, directory, query string) ([]string, error) {
+	*d.used = true
+	return nil, nil
+}
+
+func Test_SearchLiteral_RootDirNotFoundNoFallback(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	// A driver that can't run at all is meant to fall back.
+	if err := driver.SelfTest(context.Background()); err != nil {
+		t.Skip("driver does not run here")
+	}
+
+	var used bool
+	s := searchfiles.NewSearcher()
+	s.Register("driver", driver)
+	s.Register("other", otherDriver{used: &used})
+	s.SetPreferredDriver("driver")
+	s.SetFallbackOrder([]string{"driver", "other"})
+
+	_, err := s.SearchLiteral(context.Background(), "/directory-does-not-exist", "test")
+	a.Error(err)
+	a.NotErrorIs(err, searchfiles.ErrExecution)
+	a.False(used)
+}
+
+func Test_SearchLiteral_HiddenRoot(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	root := filepath.Join(t.TempDir(), ".config", "app")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"top.txt", "sub/nested.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("needle\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := driver.SearchLiteral(context.Background(), root, "needle")
+	a.NoError(err)
+	a.ElementsMatch([]string{"/top.txt", "/sub/nested.txt"}, results)
+}
+
 func Test_SearchLiteral_QueryNotLiteralMatch(driver searchfiles.Driver, t *testing.T) {
 	a := assert.New(t)
 	results, err := driver.SearchLiteral(context.Background(), getRoot(), "Test")
@@ -64,6 +233,45 @@ func Test_SearchLiteral_QueryNotLiteralMatch(driver searchfiles.Driver, t *testi
 	a.Empty(results)
 }
 
+// awkwardNames are file names that break tools whose output is split on
+// newlines or trimmed. tests/data has one with spaces, but names containing
+// newlines can't be committed as Go modules don't allow them, so these are
+// written out for each test.
+var awkwardNames = []string{"new\nline.txt", " leading.txt", "trailing.txt ", "tab\tname.txt"}
+
+func getAwkwardRoot(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for _, name := range awkwardNames {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("needle\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("haystack\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func awkwardPaths() []string {
+	var paths []string
+	for _, name := range awkwardNames {
+		paths = append(paths, "/"+name)
+	}
+	return paths
+}
+
+func Test_SearchLiteral_AwkwardNames(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := driver.SearchLiteral(context.Background(), getAwkwardRoot(t), "needle")
+	a.NoError(err)
+	a.ElementsMatch(awkwardPaths(), results)
+}
+
 func Test_SearchRegexp_PositiveCaseSingleFile(driver searchfiles.Driver, t *testing.T) {
 	a := assert.New(t)
 	results, err := driver.SearchRegexp(context.Background(), getRoot(), `\d{3}-\d{3}-\d{4}`)
@@ -75,7 +283,7 @@ func Test_SearchRegexp_PositiveCaseMultipleFiles(driver searchfiles.Driver, t *t
 	a := assert.New(t)
 	results, err := driver.SearchRegexp(context.Background(), getRoot(), `test`)
 	a.NoError(err)
-	a.ElementsMatch([]string{"/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
 }
 
 func Test_SearchRegexp_QueryNotFound(driver searchfiles.Driver, t *testing.T) {
@@ -88,10 +296,47 @@ func Test_SearchRegexp_QueryNotFound(driver searchfiles.Driver, t *testing.T) {
 func Test_SearchRegexp_InvalidRegex(driver searchfiles.Driver, t *testing.T) {
 	a := assert.New(t)
 	results, err := driver.SearchRegexp(context.Background(), getRoot(), `[`)
-	a.Error(err)
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
+	a.Empty(results)
+}
+
+// Lookarounds aren't part of RE2, so they're turned away even by drivers
+// running tools that could handle them.
+func Test_SearchRegexp_Lookaround(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := driver.SearchRegexp(context.Background(), getRoot(), `test(?= file)`)
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
 	a.Empty(results)
 }
 
+// Each of these queries is written in a way that some tools' own syntax reads
+// differently, or not at all.
+func Test_SearchRegexp_Dialect(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	for _, tc := range []struct {
+		query    string
+		expected []string
+	}{
+		{`\Qa test\E`, []string{"/ notes .txt", "/file1.txt", "/file4.txt", "/subdir/file3.txt"}},
+		{`[[:digit:]]{3}\x{2d}\d{4}`, []string{"/file4.txt"}},
+		{`(?i)ANOTHER\s+TEST`, []string{"/file2.txt"}},
+		{`\bbeta\b.+\bbeta$`, []string{"/lines.txt"}},
+		{`(?U)a.+e`, []string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/lines.txt", "/subdir/file3.txt"}},
+	} {
+		results, err := driver.SearchRegexp(context.Background(), getRoot(), tc.query)
+		a.NoError(err, tc.query)
+		a.ElementsMatch(tc.expected, results, tc.query)
+	}
+}
+
+func Test_SearchRegexp_LineAnchors(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := driver.SearchRegexp(context.Background(), getRoot(), `^third$`)
+	a.NoError(err)
+	a.Equal([]string{"/lines.txt"}, results)
+}
+
 func Test_SearchRegexp_RootDirNotFound(driver searchfiles.Driver, t *testing.T) {
 	a := assert.New(t)
 	results, err := driver.SearchRegexp(context.Background(), "/directory-does-not-exist", `test`)
@@ -99,16 +344,1093 @@ func Test_SearchRegexp_RootDirNotFound(driver searchfiles.Driver, t *testing.T)
 	a.Empty(results)
 }
 
-func Benchmark_All(driver searchfiles.Driver, b *testing.B) {
-	for _, fn := range []func(driver searchfiles.Driver, b *testing.B){
-		Benchmark_SearchLiteralWithMatches,
-		Benchmark_SearchLiteralWithoutMatches,
-		Benchmark_SearchRegexpWithMatches,
-		Benchmark_SearchRegexpWithoutMatches,
-	} {
-		pc := reflect.ValueOf(fn).Pointer()
-		f := runtime.FuncForPC(pc)
-		b.Run(path.Base(f.Name()), func(b *testing.B) { fn(driver, b) })
+func getStreamDriver(driver searchfiles.Driver, t *testing.T) searchfiles.StreamDriver {
+	streamDriver, ok := driver.(searchfiles.StreamDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.StreamDriver")
+	}
+
+	return streamDriver
+}
+
+func Test_StreamLiteral_PositiveCases(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := getStreamDriver(driver, t).StreamLiteral(context.Background(), getRoot(), "test", func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_StreamLiteral_Stop(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := getStreamDriver(driver, t).StreamLiteral(context.Background(), getRoot(), "test", func(file string) error {
+		results = append(results, file)
+		return searchfiles.ErrStop
+	})
+	a.ErrorIs(err, searchfiles.ErrStop)
+	a.Len(results, 1)
+}
+
+func Test_StreamLiteral_RootDirNotFound(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := getStreamDriver(driver, t).StreamLiteral(context.Background(), "/directory-does-not-exist", "test", func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	a.Error(err)
+	a.Empty(results)
+}
+
+// writeLargeFile writes size bytes of text that doesn't contain "needle".
+func writeLargeFile(t *testing.T, name string, size int) {
+	t.Helper()
+
+	line := []byte(strings.Repeat("lorem ipsum dolor sit amet ", 3) + "\n")
+	buf := bytes.Repeat(line, size/len(line))
+
+	if err := os.WriteFile(name, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_StreamRegexp_CancelLargeFile(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	writeLargeFile(t, filepath.Join(dir, "large.txt"), 64<<20)
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(50*time.Millisecond, cancel)
+	defer timer.Stop()
+
+	var results []string
+	err := getStreamDriver(driver, t).StreamRegexp(ctx, dir, `ne+dle`, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	cancel()
+
+	// Some drivers are fast enough to finish before they're cancelled, which
+	// is fine, but if they were cancelled they have to say so.
+	if err != nil {
+		a.ErrorIs(err, context.Canceled)
+	}
+	a.Empty(results)
+
+	// Whatever was started for the search has to stop as soon as it returns.
+	// os/exec's context watcher can take a moment to exit after Wait, so it
+	// gets a little leeway, but nowhere near enough to read the whole file.
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	a.LessOrEqual(runtime.NumGoroutine(), before, "goroutines left running after the search returned")
+}
+
+func Test_StreamRegexp_PositiveCaseMultipleFiles(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := getStreamDriver(driver, t).StreamRegexp(context.Background(), getRoot(), `test`, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_StreamRegexp_InvalidRegex(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := getStreamDriver(driver, t).StreamRegexp(context.Background(), getRoot(), `[`, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
+	a.Empty(results)
+}
+
+func getOptionsDriver(driver searchfiles.Driver, t *testing.T) searchfiles.OptionsDriver {
+	optionsDriver, ok := driver.(searchfiles.OptionsDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.OptionsDriver")
+	}
+
+	return optionsDriver
+}
+
+func searchWithOptions(driver searchfiles.Driver, t *testing.T, query string, options searchfiles.SearchOptions) ([]string, error) {
+	var results []string
+	err := getOptionsDriver(driver, t).StreamWithOptions(context.Background(), getRoot(), query, options, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support these options")
+	}
+
+	return results, err
+}
+
+func Test_SearchWithOptions_CaseInsensitive(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "TEST", searchfiles.SearchOptions{CaseInsensitive: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_SmartCaseLower(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, `this\s+is`, searchfiles.SearchOptions{Regexp: true, SmartCase: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_SmartCaseUpper(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "Test", searchfiles.SearchOptions{SmartCase: true})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_SearchWithOptions_SmartCaseClass(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, `th\w+ is a test`, searchfiles.SearchOptions{Regexp: true, SmartCase: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_WholeWord(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{WholeWord: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_WholeWordPartial(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "tes", searchfiles.SearchOptions{WholeWord: true})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_SearchWithOptions_WholeLine(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "This is a test file.", searchfiles.SearchOptions{WholeLine: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/file1.txt"}, results)
+}
+
+func Test_SearchWithOptions_WholeLineRegexp(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, `t[a-z]+d`, searchfiles.SearchOptions{Regexp: true, WholeLine: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/lines.txt"}, results)
+}
+
+func Test_SearchWithOptions_Invert(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Invert: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/lines.txt"}, results)
+}
+
+func Test_SearchWithOptions_IncludeName(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Include: []string{"file[12].txt"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/file1.txt", "/file2.txt"}, results)
+}
+
+func Test_SearchWithOptions_IncludePath(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Include: []string{"subdir/*.txt"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_ExcludeDirectory(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Exclude: []string{"subdir/"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt"}, results)
+}
+
+func Test_SearchWithOptions_ExcludeDirectoryContents(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Exclude: []string{"subdir/**"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt"}, results)
+}
+
+func Test_SearchWithOptions_ExcludeFile(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Exclude: []string{"file1.txt"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_IncludeAndExclude(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Include: []string{"*.txt"}, Exclude: []string{"file4.*", "subdir"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt"}, results)
+}
+
+func Test_SearchWithOptions_Types(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Types: []string{"go", "txt"}})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_TypesNoMatch(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Types: []string{"go"}})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_SearchWithOptions_UnknownType(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{Types: []string{"xxx-not-a-type"}})
+	a.ErrorIs(err, searchfiles.ErrUnknownFileType)
+	a.Empty(results)
+}
+
+func searchIgnoreRoot(driver searchfiles.Driver, t *testing.T, policy searchfiles.IgnorePolicy) ([]string, error) {
+	var results []string
+	err := getOptionsDriver(driver, t).StreamWithOptions(context.Background(), getIgnoreRoot(), "needle", searchfiles.SearchOptions{Ignore: policy}, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support this ignore policy")
+	}
+
+	return results, err
+}
+
+func Test_SearchWithOptions_IgnoreDefault(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchIgnoreRoot(driver, t, searchfiles.IgnorePolicy{})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/plain.txt", "/keep.log", "/sub/other.txt", "/sub/build/out.txt"}, results)
+}
+
+func Test_SearchWithOptions_IgnoreNoVCSIgnore(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchIgnoreRoot(driver, t, searchfiles.IgnorePolicy{NoVCSIgnore: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/plain.txt", "/keep.log", "/sub/other.txt", "/sub/build/out.txt", "/app.log", "/build/out.txt", "/sub/nested.txt"}, results)
+}
+
+func Test_SearchWithOptions_IgnoreNoIgnoreFiles(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchIgnoreRoot(driver, t, searchfiles.IgnorePolicy{NoIgnoreFiles: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/plain.txt", "/keep.log", "/sub/other.txt", "/sub/build/out.txt", "/secret.txt"}, results)
+}
+
+func Test_SearchWithOptions_IgnoreHidden(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchIgnoreRoot(driver, t, searchfiles.IgnorePolicy{Hidden: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/plain.txt", "/keep.log", "/sub/other.txt", "/sub/build/out.txt", "/.hidden.txt", "/.hiddendir/file.txt"}, results)
+}
+
+func Test_SearchWithOptions_IgnoreNothing(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchIgnoreRoot(driver, t, searchfiles.SearchEverything)
+	a.NoError(err)
+	a.ElementsMatch([]string{"/plain.txt", "/keep.log", "/sub/other.txt", "/sub/build/out.txt", "/app.log", "/build/out.txt", "/sub/nested.txt", "/secret.txt", "/.hidden.txt", "/.hiddendir/file.txt"}, results)
+}
+
+func searchBinaryRoot(driver searchfiles.Driver, t *testing.T, query string, options searchfiles.SearchOptions) ([]string, error) {
+	var results []string
+	err := getOptionsDriver(driver, t).StreamWithOptions(context.Background(), getBinaryRoot(), query, options, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support searching binary files")
+	}
+
+	return results, err
+}
+
+func Test_SearchWithOptions_FilesWithoutMatch(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "test", searchfiles.SearchOptions{FilesWithoutMatch: true})
+	a.NoError(err)
+	a.Equal([]string{"/lines.txt"}, results)
+}
+
+func Test_SearchWithOptions_FilesWithoutMatchRegexp(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, `be+ta|phone`, searchfiles.SearchOptions{Regexp: true, FilesWithoutMatch: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_FilesWithoutMatchAll(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "not in any file", searchfiles.SearchOptions{FilesWithoutMatch: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/lines.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_FilesWithoutMatchInvert(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	// Files where every line contains an "e".
+	results, err := searchWithOptions(driver, t, "e", searchfiles.SearchOptions{Invert: true, FilesWithoutMatch: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/ notes .txt", "/file1.txt", "/file2.txt", "/file4.txt", "/subdir/file3.txt"}, results)
+}
+
+func Test_SearchWithOptions_FilesWithoutMatchBinary(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	results, err := searchBinaryRoot(driver, t, "not in any file", searchfiles.SearchOptions{FilesWithoutMatch: true})
+	a.NoError(err)
+	a.Equal([]string{"/text.txt"}, results)
+
+	results, err = searchBinaryRoot(driver, t, "not in any file", searchfiles.SearchOptions{FilesWithoutMatch: true, Binary: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/data.bin", "/text.txt"}, results)
+}
+
+func Test_SearchWithOptions_FilesWithoutMatchAwkwardNames(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []string
+	err := getOptionsDriver(driver, t).StreamWithOptions(context.Background(), getAwkwardRoot(t), "haystack", searchfiles.SearchOptions{FilesWithoutMatch: true}, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	a.NoError(err)
+	a.ElementsMatch(awkwardPaths(), results)
+}
+
+func Test_SearchWithOptions_BinarySkipped(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchBinaryRoot(driver, t, "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal([]string{"/text.txt"}, results)
+}
+
+func Test_SearchWithOptions_BinarySkippedRegexp(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchBinaryRoot(driver, t, "ne+dle", searchfiles.SearchOptions{Regexp: true})
+	a.NoError(err)
+	a.Equal([]string{"/text.txt"}, results)
+}
+
+func Test_SearchWithOptions_Binary(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchBinaryRoot(driver, t, "needle", searchfiles.SearchOptions{Binary: true})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/data.bin", "/text.txt"}, results)
+}
+
+func Test_SearchWithOptions_Multiline(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	results, err := searchWithOptions(driver, t, `beta\s+third`, searchfiles.SearchOptions{Regexp: true, Multiline: true})
+	a.NoError(err)
+	a.Equal([]string{"/lines.txt"}, results)
+
+	// Without Multiline, the same query can only look within a line.
+	results, err = searchWithOptions(driver, t, `beta\s+third`, searchfiles.SearchOptions{Regexp: true})
+	a.NoError(err)
+	a.Empty(results)
+
+	results, err = searchWithOptions(driver, t, `beta.third`, searchfiles.SearchOptions{Regexp: true, Multiline: true})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_SearchWithOptions_MultilineLiteral(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchWithOptions(driver, t, "beta\nthird", searchfiles.SearchOptions{Multiline: true})
+	a.NoError(err)
+	a.Equal([]string{"/lines.txt"}, results)
+}
+
+func Test_SearchWithOptions_MultilineAnchors(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	results, err := searchWithOptions(driver, t, `beta$\n^third$`, searchfiles.SearchOptions{Regexp: true, Multiline: true})
+	a.NoError(err)
+	a.Equal([]string{"/lines.txt"}, results)
+
+	results, err = searchWithOptions(driver, t, `^beta`, searchfiles.SearchOptions{Regexp: true, Multiline: true})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_SearchWithOptions_MultilineBinarySkipped(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchBinaryRoot(driver, t, "needle", searchfiles.SearchOptions{Multiline: true})
+	a.NoError(err)
+	a.Equal([]string{"/text.txt"}, results)
+}
+
+func Test_MatchWithOptions_IgnoreDefault(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getIgnoreRoot(), "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+
+	var files []string
+	for _, e := range results {
+		files = append(files, e.Path)
+	}
+
+	a.ElementsMatch([]string{"/plain.txt", "/keep.log", "/sub/other.txt", "/sub/build/out.txt"}, files)
+}
+
+func Test_MatchWithOptions_Exclude(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getRoot(), "beta", searchfiles.SearchOptions{Exclude: []string{"*.txt"}})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_MatchWithOptions_CaseInsensitive(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getRoot(), "BETA", searchfiles.SearchOptions{CaseInsensitive: true})
+	a.NoError(err)
+	a.ElementsMatch(expectedMatches(results), results)
+}
+
+func Test_MatchWithOptions_Invert(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getRoot(), "e", searchfiles.SearchOptions{Invert: true})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support these options")
+	}
+	a.NoError(err)
+
+	offset := int64(44)
+	if len(results) > 0 && results[0].Offset == -1 {
+		offset = -1
+	}
+
+	a.Equal([]searchfiles.Match{{Path: "/lines.txt", LineNumber: 3, Offset: offset, Line: "third"}}, results)
+}
+
+func Test_MatchWithOptions_BinarySkipped(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getBinaryRoot(), "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+
+	var files []string
+	for _, e := range results {
+		files = append(files, e.Path)
+	}
+
+	a.Equal([]string{"/text.txt"}, files)
+}
+
+func Test_MatchWithOptions_Multiline(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getRoot(), `beta\nthird|first`, searchfiles.SearchOptions{Regexp: true, Multiline: true})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support multiline matching")
+	}
+	a.NoError(err)
+
+	// Matches on neighbouring lines are reported together, as rg does.
+	a.Equal([]searchfiles.Match{{
+		Path:       "/lines.txt",
+		LineNumber: 1,
+		Offset:     0,
+		Column:     1,
+		Line:       "first line\n  second line with beta and beta\nthird",
+		Submatches: []searchfiles.Submatch{
+			{Start: 0, End: 5, Text: "first"},
+			{Start: 39, End: 49, Text: "beta\nthird"},
+		},
+	}}, results)
+}
+
+func searchEncodingRoot(driver searchfiles.Driver, t *testing.T, query string, options searchfiles.SearchOptions) ([]string, error) {
+	var results []string
+	err := getOptionsDriver(driver, t).StreamWithOptions(context.Background(), getEncodingRoot(), query, options, func(file string) error {
+		results = append(results, file)
+		return nil
+	})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support transcoding files")
+	}
+
+	return results, err
+}
+
+// skipUntranscoded skips the test if the driver can't transcode files, since
+// then it sees UTF-16 files as binary whatever the encoding.
+func skipUntranscoded(driver searchfiles.Driver, t *testing.T) {
+	_, _ = searchEncodingRoot(driver, t, "needle", searchfiles.SearchOptions{Encoding: searchfiles.EncodingLatin1})
+}
+
+func Test_SearchWithOptions_EncodingAuto(driver searchfiles.Driver, t *testing.T) {
+	skipUntranscoded(driver, t)
+
+	a := assert.New(t)
+	results, err := searchEncodingRoot(driver, t, "needle", searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/latin1.txt", "/utf16be-bom.txt", "/utf16le-bom.txt", "/utf8.txt"}, results)
+}
+
+func Test_SearchWithOptions_EncodingLatin1(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	results, err := searchEncodingRoot(driver, t, "café", searchfiles.SearchOptions{Encoding: searchfiles.EncodingLatin1})
+	a.NoError(err)
+	a.Equal([]string{"/latin1.txt"}, results)
+
+	results, err = searchEncodingRoot(driver, t, "café", searchfiles.SearchOptions{Encoding: searchfiles.EncodingUTF8})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_SearchWithOptions_EncodingUTF16(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	// Files with a byte order mark are read as it says, whatever the
+	// encoding.
+	results, err := searchEncodingRoot(driver, t, "needle", searchfiles.SearchOptions{Encoding: searchfiles.EncodingUTF16LE})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/utf16be-bom.txt", "/utf16le-bom.txt", "/utf16le.txt"}, results)
+
+	results, err = searchEncodingRoot(driver, t, "needle", searchfiles.SearchOptions{Encoding: searchfiles.EncodingUTF16BE})
+	a.NoError(err)
+	a.ElementsMatch([]string{"/utf16be-bom.txt", "/utf16le-bom.txt"}, results)
+}
+
+func Test_MatchWithOptions_EncodingAuto(driver searchfiles.Driver, t *testing.T) {
+	skipUntranscoded(driver, t)
+
+	a := assert.New(t)
+	results, err := getOptionsDriver(driver, t).MatchWithOptions(context.Background(), getEncodingRoot(), "encoded", searchfiles.SearchOptions{})
+	a.NoError(err)
+
+	for i := range results {
+		results[i].Offset = 0
+	}
+
+	submatches := []searchfiles.Submatch{{Start: 3, End: 10, Text: "encoded"}}
+	a.ElementsMatch([]searchfiles.Match{
+		{Path: "/utf16be-bom.txt", LineNumber: 1, Column: 4, Line: "an encoded needle here", Submatches: submatches},
+		{Path: "/utf16le-bom.txt", LineNumber: 2, Column: 4, Line: "an encoded needle here", Submatches: submatches},
+	}, results)
+}
+
+func getCountDriver(driver searchfiles.Driver, t *testing.T) searchfiles.CountDriver {
+	countDriver, ok := driver.(searchfiles.CountDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.CountDriver")
+	}
+
+	return countDriver
+}
+
+func countWithOptions(driver searchfiles.Driver, t *testing.T, query string, mode searchfiles.CountMode, options searchfiles.SearchOptions) (searchfiles.Counts, error) {
+	counts, err := getCountDriver(driver, t).CountWithOptions(context.Background(), getRoot(), query, mode, options)
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support these options")
+	}
+
+	return counts, err
+}
+
+func Test_CountWithOptions_Lines(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	counts, err := countWithOptions(driver, t, "beta", searchfiles.CountLines, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal(searchfiles.Counts{"/lines.txt": 2}, counts)
+	a.Equal(2, counts.Total())
+}
+
+func Test_CountWithOptions_Occurrences(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	counts, err := countWithOptions(driver, t, "beta", searchfiles.CountOccurrences, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal(searchfiles.Counts{"/lines.txt": 3}, counts)
+	a.Equal(3, counts.Total())
+}
+
+func Test_CountWithOptions_AwkwardNames(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	countDriver := getCountDriver(driver, t)
+
+	expected := searchfiles.Counts{}
+	for _, path := range awkwardPaths() {
+		expected[path] = 1
+	}
+
+	for _, mode := range []searchfiles.CountMode{searchfiles.CountLines, searchfiles.CountOccurrences} {
+		counts, err := countDriver.CountWithOptions(context.Background(), getAwkwardRoot(t), "needle", mode, searchfiles.SearchOptions{})
+		if errors.Is(err, searchfiles.ErrUnimplemented) {
+			t.Skip("driver does not support these options")
+		}
+		a.NoError(err, mode)
+		a.Equal(expected, counts, mode)
+	}
+}
+
+func Test_CountWithOptions_ManyFiles(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	counts, err := countWithOptions(driver, t, `i[sn]`, searchfiles.CountOccurrences, searchfiles.SearchOptions{Regexp: true})
+	a.NoError(err)
+	a.Equal(searchfiles.Counts{
+		"/ notes .txt":      3,
+		"/file1.txt":        2,
+		"/file2.txt":        2,
+		"/file4.txt":        2,
+		"/lines.txt":        2,
+		"/subdir/file3.txt": 3,
+	}, counts)
+	a.Equal(14, counts.Total())
+}
+
+func Test_CountWithOptions_Invert(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	for _, mode := range []searchfiles.CountMode{searchfiles.CountLines, searchfiles.CountOccurrences} {
+		counts, err := countWithOptions(driver, t, "e", mode, searchfiles.SearchOptions{Invert: true})
+		a.NoError(err)
+		a.Equal(searchfiles.Counts{"/lines.txt": 1}, counts)
+	}
+}
+
+func Test_CountWithOptions_QueryNotFound(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	counts, err := countWithOptions(driver, t, "not in any file", searchfiles.CountLines, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Empty(counts)
+	a.Equal(0, counts.Total())
+}
+
+func Test_CountWithOptions_Multiline(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	options := searchfiles.SearchOptions{Regexp: true, Multiline: true}
+
+	counts, err := countWithOptions(driver, t, `beta\nthird|fourth`, searchfiles.CountLines, options)
+	a.NoError(err)
+	a.Equal(searchfiles.Counts{"/lines.txt": 3}, counts)
+
+	counts, err = countWithOptions(driver, t, `beta\nthird|fourth`, searchfiles.CountOccurrences, options)
+	a.NoError(err)
+	a.Equal(searchfiles.Counts{"/lines.txt": 2}, counts)
+}
+
+func getMultiDriver(driver searchfiles.Driver, t *testing.T) searchfiles.MultiDriver {
+	multiDriver, ok := driver.(searchfiles.MultiDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.MultiDriver")
+	}
+
+	return multiDriver
+}
+
+func searchMulti(driver searchfiles.Driver, t *testing.T, queries []string, mode searchfiles.MultiMode, options searchfiles.SearchOptions) ([]searchfiles.MultiResult, error) {
+	var results []searchfiles.MultiResult
+	err := getMultiDriver(driver, t).StreamMulti(context.Background(), getRoot(), queries, mode, options, func(result searchfiles.MultiResult) error {
+		results = append(results, result)
+		return nil
+	})
+
+	return results, err
+}
+
+func Test_StreamMulti_AnyOf(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchMulti(driver, t, []string{"another", "subdirectory", "beta", "phone", "test"}, searchfiles.AnyOf, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.ElementsMatch([]searchfiles.MultiResult{
+		{Path: "/ notes .txt", Patterns: []int{4}},
+		{Path: "/file1.txt", Patterns: []int{4}},
+		{Path: "/file2.txt", Patterns: []int{0, 4}},
+		{Path: "/file4.txt", Patterns: []int{3, 4}},
+		{Path: "/lines.txt", Patterns: []int{2}},
+		{Path: "/subdir/file3.txt", Patterns: []int{1, 4}},
+	}, results)
+}
+
+func Test_StreamMulti_AllOf(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchMulti(driver, t, []string{"test", "phone"}, searchfiles.AllOf, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal([]searchfiles.MultiResult{{Path: "/file4.txt", Patterns: []int{0, 1}}}, results)
+}
+
+func Test_StreamMulti_Regexp(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := searchMulti(driver, t, []string{`[0-9]{3}-[0-9]{4}`, `^third$`}, searchfiles.AnyOf, searchfiles.SearchOptions{Regexp: true})
+	a.NoError(err)
+	a.ElementsMatch([]searchfiles.MultiResult{
+		{Path: "/file4.txt", Patterns: []int{0}},
+		{Path: "/lines.txt", Patterns: []int{1}},
+	}, results)
+}
+
+func Test_StreamMulti_SmartCase(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	// The uppercase letter in the second pattern makes both of them case
+	// sensitive.
+	results, err := searchMulti(driver, t, []string{"this", "Phone"}, searchfiles.AnyOf, searchfiles.SearchOptions{SmartCase: true})
+	a.NoError(err)
+	a.Empty(results)
+
+	results, err = searchMulti(driver, t, []string{"this", "phone"}, searchfiles.AllOf, searchfiles.SearchOptions{SmartCase: true})
+	a.NoError(err)
+	a.Equal([]searchfiles.MultiResult{{Path: "/file4.txt", Patterns: []int{0, 1}}}, results)
+}
+
+func Test_StreamMulti_ManyPatterns(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	var queries []string
+	for i := 0; i < 40; i++ {
+		queries = append(queries, fmt.Sprintf("identifier%02d", i))
+	}
+	queries = append(queries, "phone")
+
+	results, err := searchMulti(driver, t, queries, searchfiles.AnyOf, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Equal([]searchfiles.MultiResult{{Path: "/file4.txt", Patterns: []int{40}}}, results)
+}
+
+func Test_StreamMulti_Stop(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	var results []searchfiles.MultiResult
+	err := getMultiDriver(driver, t).StreamMulti(context.Background(), getRoot(), []string{"test", "beta"}, searchfiles.AnyOf, searchfiles.SearchOptions{}, func(result searchfiles.MultiResult) error {
+		results = append(results, result)
+		return searchfiles.ErrStop
+	})
+	a.ErrorIs(err, searchfiles.ErrStop)
+	a.Len(results, 1)
+}
+
+func getContextDriver(driver searchfiles.Driver, t *testing.T) searchfiles.ContextDriver {
+	contextDriver, ok := driver.(searchfiles.ContextDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.ContextDriver")
+	}
+
+	return contextDriver
+}
+
+func contextMatches(results []searchfiles.ContextMatch) []searchfiles.Match {
+	var matches []searchfiles.Match
+	for _, e := range results {
+		matches = append(matches, e.Match)
+	}
+
+	return matches
+}
+
+func Test_MatchWithContext_Overlapping(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getContextDriver(driver, t).MatchWithContext(context.Background(), getRoot(), "beta", 1, 1, searchfiles.SearchOptions{})
+	a.NoError(err)
+
+	// "third" would be context for both matches, but it's only given to the
+	// first.
+	expected := expectedMatches(contextMatches(results))
+	a.Equal([]searchfiles.ContextMatch{
+		{
+			Match:  expected[0],
+			Before: []searchfiles.ContextLine{{LineNumber: 1, Line: "first line"}},
+			After:  []searchfiles.ContextLine{{LineNumber: 3, Line: "third"}},
+		},
+		{
+			Match: expected[1],
+		},
+	}, results)
+}
+
+func Test_MatchWithContext_BeforeAndAfter(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getContextDriver(driver, t).MatchWithContext(context.Background(), getRoot(), "third", 2, 5, searchfiles.SearchOptions{})
+	a.NoError(err)
+	if a.Len(results, 1) {
+		a.Equal(3, results[0].LineNumber)
+		a.Equal([]searchfiles.ContextLine{{LineNumber: 1, Line: "first line"}, {LineNumber: 2, Line: "  second line with beta and beta"}}, results[0].Before)
+		a.Equal([]searchfiles.ContextLine{{LineNumber: 4, Line: "\tfourth beta"}}, results[0].After)
+	}
+}
+
+func Test_MatchWithContext_NoContext(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getContextDriver(driver, t).MatchWithContext(context.Background(), getRoot(), "beta", 0, 0, searchfiles.SearchOptions{})
+	a.NoError(err)
+
+	expected := expectedMatches(contextMatches(results))
+	a.Equal([]searchfiles.ContextMatch{{Match: expected[0]}, {Match: expected[1]}}, results)
+}
+
+func Test_MatchWithContext_QueryNotFound(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getContextDriver(driver, t).MatchWithContext(context.Background(), getRoot(), "notfound", 2, 2, searchfiles.SearchOptions{})
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_MatchWithContext_Multiline(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getContextDriver(driver, t).MatchWithContext(context.Background(), getRoot(), `line\n\s+second`, 0, 1, searchfiles.SearchOptions{Regexp: true, Multiline: true})
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver does not support multiline matching")
+	}
+	a.NoError(err)
+	if a.Len(results, 1) {
+		a.Equal(1, results[0].LineNumber)
+		a.Equal("first line\n  second line with beta and beta", results[0].Line)
+		a.Empty(results[0].Before)
+		a.Equal([]searchfiles.ContextLine{{LineNumber: 3, Line: "third"}}, results[0].After)
+	}
+}
+
+func getMatchDriver(driver searchfiles.Driver, t *testing.T) searchfiles.MatchDriver {
+	matchDriver, ok := driver.(searchfiles.MatchDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.MatchDriver")
+	}
+
+	return matchDriver
+}
+
+// expectedMatches returns the matches for "beta" in lines.txt. Drivers that
+// can't report byte offsets use -1, so the expected offsets are adjusted to
+// suit.
+func expectedMatches(actual []searchfiles.Match) []searchfiles.Match {
+	expected := []searchfiles.Match{
+		{
+			Path:       "/lines.txt",
+			LineNumber: 2,
+			Offset:     11,
+			Column:     20,
+			Line:       "  second line with beta and beta",
+			Submatches: []searchfiles.Submatch{{Start: 19, End: 23, Text: "beta"}, {Start: 28, End: 32, Text: "beta"}},
+		},
+		{
+			Path:       "/lines.txt",
+			LineNumber: 4,
+			Offset:     50,
+			Column:     9,
+			Line:       "\tfourth beta",
+			Submatches: []searchfiles.Submatch{{Start: 8, End: 12, Text: "beta"}},
+		},
+	}
+
+	if len(actual) > 0 && actual[0].Offset == -1 {
+		for i := range expected {
+			expected[i].Offset = -1
+		}
+	}
+
+	return expected
+}
+
+func Test_MatchLiteral_Positions(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getMatchDriver(driver, t).MatchLiteral(context.Background(), getRoot(), "beta")
+	a.NoError(err)
+	a.ElementsMatch(expectedMatches(results), results)
+}
+
+func Test_MatchLiteral_AwkwardNames(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getMatchDriver(driver, t).MatchLiteral(context.Background(), getAwkwardRoot(t), "needle")
+	a.NoError(err)
+
+	var paths []string
+	for _, m := range results {
+		paths = append(paths, m.Path)
+		a.Equal("needle", m.Line, m.Path)
+		a.Equal(1, m.LineNumber, m.Path)
+	}
+	a.ElementsMatch(awkwardPaths(), paths)
+}
+
+func Test_MatchLiteral_QueryNotFound(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getMatchDriver(driver, t).MatchLiteral(context.Background(), getRoot(), "notfound")
+	a.NoError(err)
+	a.Empty(results)
+}
+
+func Test_MatchLiteral_RootDirNotFound(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getMatchDriver(driver, t).MatchLiteral(context.Background(), "/directory-does-not-exist", "test")
+	a.Error(err)
+	a.Empty(results)
+}
+
+func Test_MatchRegexp_Positions(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getMatchDriver(driver, t).MatchRegexp(context.Background(), getRoot(), `b[a-z]ta`)
+	a.NoError(err)
+	a.ElementsMatch(expectedMatches(results), results)
+}
+
+func Test_MatchRegexp_InvalidRegex(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+	results, err := getMatchDriver(driver, t).MatchRegexp(context.Background(), getRoot(), `[`)
+	a.ErrorIs(err, searchfiles.ErrInvalidQuery)
+	a.Empty(results)
+}
+
+// Test_Decompress checks a driver that's been set up to search the contents
+// of compressed files.
+func Test_Decompress(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	results, err := driver.SearchLiteral(context.Background(), getCompressedRoot(), "needle")
+	if errors.Is(err, searchfiles.ErrUnimplemented) {
+		t.Skip("driver can't search compressed files")
+	}
+	a.NoError(err)
+	a.ElementsMatch([]string{"/app.log.1.gz", "/app.log.2.bz2", "/plain.log"}, results)
+
+	results, err = driver.SearchLiteral(context.Background(), getCompressedRoot(), "rotated")
+	a.NoError(err)
+	a.Equal([]string{"/app.log.1.gz"}, results)
+}
+
+// Test_Capabilities checks that a driver can search with every option it
+// says it can.
+func Test_Capabilities(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	capabilitiesDriver, ok := driver.(searchfiles.CapabilitiesDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.CapabilitiesDriver")
+	}
+
+	capabilities, err := capabilitiesDriver.Capabilities(context.Background())
+	if !a.NoError(err) {
+		return
+	}
+
+	for _, tc := range []struct {
+		capability searchfiles.Capabilities
+		options    searchfiles.SearchOptions
+	}{
+		{searchfiles.CapabilityRegexp, searchfiles.SearchOptions{Regexp: true}},
+		{searchfiles.CapabilityInvert, searchfiles.SearchOptions{Invert: true}},
+		{searchfiles.CapabilityMultiline, searchfiles.SearchOptions{Multiline: true}},
+		{searchfiles.CapabilityBinary, searchfiles.SearchOptions{Binary: true}},
+		{searchfiles.CapabilityEncoding, searchfiles.SearchOptions{Encoding: searchfiles.EncodingLatin1}},
+		{searchfiles.CapabilityVCSIgnore, searchfiles.SearchOptions{}},
+	} {
+		if !capabilities.Has(tc.capability) {
+			continue
+		}
+
+		err := getOptionsDriver(driver, t).StreamWithOptions(context.Background(), getRoot(), "test", tc.options, func(file string) error {
+			return nil
+		})
+		a.NoError(err, tc.capability.String())
+	}
+}
+
+func Test_Version(driver searchfiles.Driver, t *testing.T) {
+	a := assert.New(t)
+
+	versionDriver, ok := driver.(searchfiles.VersionDriver)
+	if !ok {
+		t.Skip("driver does not implement searchfiles.VersionDriver")
+	}
+
+	v, err := versionDriver.Version(context.Background())
+	if a.NoError(err) {
+		a.Equal(1, v.Compare(searchfiles.Version{}), v.String())
+	}
+}
+
+func Benchmark_All(driver searchfiles.Driver, b *testing.B) {
+	for _, fn := range []func(driver searchfiles.Driver, b *testing.B){
+		Benchmark_SearchLiteralWithMatches,
+		Benchmark_SearchLiteralWithoutMatches,
+		Benchmark_SearchRegexpWithMatches,
+		Benchmark_SearchRegexpWithoutMatches,
+	} {
+		pc := reflect.ValueOf(fn).Pointer()
+		f := runtime.FuncForPC(pc)
+		b.Run(path.Base(f.Name()), func(b *testing.B) { fn(driver, b) })
+	}
+
+	b.Run("Benchmark_LargeCorpus", func(b *testing.B) { Benchmark_LargeCorpus(driver, b) })
+}
+
+// generateCorpus writes a few thousand files of random words into a
+// temporary directory. It's the same every time, and about one file in fifty
+// contains "needle".
+func generateCorpus(b *testing.B) string {
+	b.Helper()
+
+	root := b.TempDir()
+
+	words := strings.Fields("lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua func return struct interface package import")
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("dir%02d", i%20), fmt.Sprintf("sub%02d", i%7))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			b.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		for line := 0; line < 100; line++ {
+			for word := 0; word < 10; word++ {
+				buf.WriteString(words[rnd.Intn(len(words))])
+				buf.WriteByte(' ')
+			}
+			if i%50 == 0 && line == 50 {
+				buf.WriteString("needle")
+			}
+			buf.WriteByte('\n')
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%04d.txt", i)), buf.Bytes(), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+// Benchmark_LargeCorpus searches a generated corpus that's big enough for the
+// cost of reading files to outweigh the cost of starting a search.
+func Benchmark_LargeCorpus(driver searchfiles.Driver, b *testing.B) {
+	root := generateCorpus(b)
+	ctx := context.Background()
+
+	for _, bb := range []struct {
+		name  string
+		query string
+		fn    func(ctx context.Context, directory, query string) ([]string, error)
+	}{
+		{"LiteralWithMatches", "needle", driver.SearchLiteral},
+		{"LiteralWithoutMatches", "needle-xxx-not-found", driver.SearchLiteral},
+		{"RegexpWithMatches", `ne+dle`, driver.SearchRegexp},
+		{"RegexpWithoutMatches", `ne+dle-xxx-not-found`, driver.SearchRegexp},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _ = bb.fn(ctx, root, bb.query)
+			}
+		})
 	}
 }
 